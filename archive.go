@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Archive stores fetched pages so scrapes can be parsed again.
+// Pages are gzipped and named by the sha256 of their content so identical pages are stored once.
+// Each scrape has a manifest listing its pages
+//
+//	<dir>/objects/ab/abcdef....html.gz
+//	<dir>/runs/<run id>.json
+type Archive struct {
+	Dir string
+}
+
+// ArchivedRun is the manifest of a scrape
+type ArchivedRun struct {
+	ID         string         `json:"id"`
+	RunID      int            `json:"run_id"`
+	Source     string         `json:"source"`
+	Chain      string         `json:"chain"`
+	Symbol     string         `json:"symbol"`
+	CapturedAt time.Time      `json:"captured_at"`
+	Pages      []ArchivedPage `json:"pages"`
+}
+
+type ArchivedPage struct {
+	Page int    `json:"page"`
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+func newArchive(dir string) *Archive {
+	if dir == "" {
+		return nil
+	}
+	return &Archive{dir}
+}
+
+func newArchivedRun(s Scraper, runID int, report HealthReport) ArchivedRun {
+	// postgres only keeps microseconds
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	run := ArchivedRun{
+		ID:         fmt.Sprintf("%s-%s-%s", now.Format("20060102T150405Z"), s.Source(), strings.ToLower(s.Symbol())),
+		RunID:      runID,
+		Source:     s.Source(),
+		Chain:      s.Chain(),
+		Symbol:     s.Symbol(),
+		CapturedAt: now,
+	}
+	for _, p := range report.Pages {
+		run.Pages = append(run.Pages, ArchivedPage{p.Page, p.URL, p.Hash})
+	}
+	return run
+}
+
+func (a *Archive) objectPath(hash string) string {
+	return filepath.Join(a.Dir, "objects", hash[:2], hash+".html.gz")
+}
+
+func (a *Archive) runPath(id string) string {
+	return filepath.Join(a.Dir, "runs", id+".json")
+}
+
+// Put stores content and returns its hash. Does nothing if there is no archive
+func (a *Archive) Put(content []byte) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	path := a.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		// already stored
+		return hash, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(content)
+	if err != nil {
+		return "", err
+	}
+	err = zw.Close()
+	if err != nil {
+		return "", err
+	}
+	return hash, writeFile(path, buf.Bytes())
+}
+
+func (a *Archive) Get(hash string) ([]byte, error) {
+	compressed, err := ioutil.ReadFile(a.objectPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+func (a *Archive) SaveRun(run ArchivedRun) error {
+	if a == nil {
+		return nil
+	}
+	content, err := json.MarshalIndent(run, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(a.runPath(run.ID), content)
+}
+
+func (a *Archive) LoadRun(id string) (ArchivedRun, error) {
+	var run ArchivedRun
+	content, err := ioutil.ReadFile(a.runPath(id))
+	if err != nil {
+		return run, err
+	}
+	err = json.Unmarshal(content, &run)
+	return run, err
+}
+
+// writeFile writes to a temporary file first so a crash never leaves a partial file behind
+func writeFile(path string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// reparse runs the current parser of a scraper over archived pages
+func reparse(s Scraper, a *Archive, run ArchivedRun) ([]Wallet, HealthReport, error) {
+	report := HealthReport{Source: s.Source(), Chain: s.Chain(), Symbol: s.Symbol()}
+	var pages [][]Wallet
+	for _, p := range run.Pages {
+		if p.Hash == "" {
+			return nil, report, fmt.Errorf("page %d of %s was not archived", p.Page, run.ID)
+		}
+		content, err := a.Get(p.Hash)
+		if err != nil {
+			return nil, report, err
+		}
+		ws, health, err := parsePage(s, p.Page, p.URL, content)
+		if err != nil {
+			return nil, report, err
+		}
+		health.Hash = p.Hash
+		pages = append(pages, ws)
+		report.Pages = append(report.Pages, health)
+	}
+	return merge(pages), report, nil
+}
+
+// replay replaces the balances of an archived run with what the current parsers read from its pages
+func replay(ctx context.Context, conn *pgxpool.Pool, a *Archive, id string, chains []Blockchain, tokens []TokenContract, classifier Classifier) error {
+	if a == nil {
+		return errors.New("no archive configured")
+	}
+	run, err := a.LoadRun(id)
+	if err != nil {
+		return err
+	}
+	blockchain, ok := findChain(chains, run.Chain)
+	if !ok {
+		return fmt.Errorf("%s is not a configured chain", run.Chain)
+	}
+	var scraper Scraper
+	for _, s := range scrapersFor(blockchain, tokens) {
+		if s.Source() == run.Source && s.Symbol() == run.Symbol {
+			scraper = s
+			break
+		}
+	}
+	if scraper == nil {
+		return fmt.Errorf("no scraper for %s %s on %s", run.Source, run.Symbol, run.Chain)
+	}
+	wallets, report, err := reparse(scraper, a, run)
+	if err != nil {
+		return err
+	}
+	if !report.Healthy() {
+		return fmt.Errorf("%s still does not match the expected layout:\n%s", run.ID, strings.Join(report.Problems(), "\n"))
+	}
+	if run.RunID == 0 {
+		// archived before runs were tracked
+		err = conn.QueryRow(ctx, `
+			SELECT b.run_id
+			FROM balance b
+			JOIN whale w USING (whale_id)
+			WHERE w.blockchain = $1
+			AND b.symbol = $2
+			AND b.created_at = $3
+			LIMIT 1;
+		`, run.Chain, run.Symbol, run.CapturedAt).Scan(&run.RunID)
+		if err != nil {
+			return fmt.Errorf("no balances found for %s: %w", run.ID, err)
+		}
+	}
+	// percentages of tokens are of the supply read in the same batch
+	supply, err := runSupply(ctx, conn, run.RunID)
+	if err != nil {
+		return err
+	}
+	classifier, err = classifier.labelled(ctx, conn, wallets)
+	if err != nil {
+		return err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	_, _, count := report.totals()
+	batch.Queue(`DELETE FROM balance WHERE run_id = $1;`, run.RunID)
+	logScrape(batch, withPercentages(classifier.classifyAll(wallets), supply), run.RunID, run.CapturedAt)
+	batch.Queue(`
+		UPDATE scrape_run
+		SET status = $2, row_count = $3, archive_id = $4
+		WHERE run_id = $1;
+	`, run.RunID, runSuccess, count, run.ID)
+	err = commit(ctx, tx, batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replayed %s: %d wallets\n", run.ID, count)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArchiveReparse(t *testing.T) {
+	server := serveFixture(t, "testdata/bitinfocharts.html")
+	archive := newArchive(t.TempDir())
+	s := pagedScraper{bitinfochartsScraper{BaseURL: server.URL, chain: bitcoin}, 2}
+	scraped, report, err := scrape(context.Background(), testFetcher(server), archive, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identical pages are stored once
+	if report.Pages[0].Hash == "" || report.Pages[0].Hash != report.Pages[1].Hash {
+		t.Fatalf("unexpected hashes %q %q", report.Pages[0].Hash, report.Pages[1].Hash)
+	}
+	run := newArchivedRun(s, 1, report)
+	err = archive.SaveRun(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := archive.LoadRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.CapturedAt.Equal(run.CapturedAt) || len(loaded.Pages) != 2 {
+		t.Fatalf("got %+v, want %+v", loaded, run)
+	}
+	replayed, _, err := reparse(s, archive, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, replayed, scraped)
+}
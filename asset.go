@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Asset is a coin or token whose whales get their own series
+type Asset struct {
+	// Key names the asset in points and summaries.
+	// Its symbol unless an asset of ethereum or an earlier chain has the same symbol
+	Key        string
+	Blockchain Blockchain
+	Symbol     string
+	// Address of the token contract. Empty for native coins
+	Address    string
+	Price      float64
+	Stablecoin bool
+}
+
+// assetsFor lists the native coin of each chain followed by the configured tokens of those chains
+func assetsFor(chains []Blockchain, tokens []TokenContract) []Asset {
+	var assets []Asset
+	for _, c := range chains {
+		assets = append(assets, Asset{Blockchain: c, Symbol: c.Symbol, Price: c.Price})
+	}
+	for _, t := range tokens {
+		c, ok := findChain(chains, t.Blockchain)
+		if !ok {
+			continue
+		}
+		assets = append(assets, Asset{Blockchain: c, Symbol: t.Symbol, Address: t.Address, Stablecoin: t.stablecoin()})
+	}
+	taken := map[string]bool{}
+	// ethereum keeps plain symbols
+	for i, a := range assets {
+		if a.Blockchain.Name == ethereum.Name {
+			assets[i].Key = a.Symbol
+			taken[a.Symbol] = true
+		}
+	}
+	for i, a := range assets {
+		if a.Key != "" {
+			continue
+		}
+		key := a.Symbol
+		if taken[key] {
+			key = fmt.Sprintf("%s (%s)", a.Symbol, a.Blockchain.Name)
+		}
+		assets[i].Key = key
+		taken[key] = true
+	}
+	return assets
+}
+
+// stablecoin is true for tokens pegged to the dollar like USDT and USDC
+func (t TokenContract) stablecoin() bool {
+	if t.Stablecoin != nil {
+		return *t.Stablecoin
+	}
+	return strings.Contains(t.Symbol, "USD")
+}
+
+// fetchTokenPrices reads the usd price of tokens from coingecko keyed by lowercase contract address
+func fetchTokenPrices(ctx context.Context, f *Fetcher, assets []Asset) (map[string]float64, error) {
+	addresses := map[string][]string{}
+	var platforms []string
+	for _, a := range assets {
+		if a.Address == "" {
+			continue
+		}
+		platform := a.Blockchain.pricePlatform()
+		if _, ok := addresses[platform]; !ok {
+			platforms = append(platforms, platform)
+		}
+		addresses[platform] = append(addresses[platform], strings.ToLower(a.Address))
+	}
+	prices := map[string]float64{}
+	for _, platform := range platforms {
+		requestURL := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/token_price/%s?contract_addresses=%s&vs_currencies=usd", url.PathEscape(platform), strings.Join(addresses[platform], ","))
+		body, err := f.Get(ctx, requestURL)
+		if err != nil {
+			return prices, err
+		}
+		var result map[string]map[string]float64
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			return prices, err
+		}
+		for address, price := range result {
+			prices[strings.ToLower(address)] = price["usd"]
+		}
+	}
+	return prices, nil
+}
+
+// priceAssets sets the price of coins from their chain and of tokens from tokenPrices.
+// Stablecoins without a price are assumed to be at their peg
+func priceAssets(assets []Asset, pricedChains []Blockchain, tokenPrices map[string]float64) []Asset {
+	for i, a := range assets {
+		if a.Address == "" {
+			if c, ok := findChain(pricedChains, a.Blockchain.Name); ok {
+				assets[i].Price = c.Price
+			}
+			continue
+		}
+		assets[i].Price = tokenPrices[strings.ToLower(a.Address)]
+		if assets[i].Price == 0 && a.Stablecoin {
+			assets[i].Price = 1
+		}
+	}
+	return assets
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssetsFor(t *testing.T) {
+	arbitrum := Blockchain{Name: "arbitrum", Symbol: "ETH", RichList: "etherscan", Explorer: "https://arbiscan.io"}
+	bsc := Blockchain{Name: "bsc", Symbol: "BNB", RichList: "etherscan", Explorer: "https://bscscan.com"}
+	notStable := false
+	tokens := []TokenContract{
+		{Symbol: "USDT", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Blockchain: "ethereum"},
+		{Symbol: "LINK", Address: "0x514910771AF9Ca656af840dff83E8264EcF986CA", Blockchain: "ethereum"},
+		{Symbol: "USDT", Address: "0x55d398326f99059ff775485246999027b3197955", Blockchain: "bsc"},
+		{Symbol: "USDD", Address: "0x0c10bf8fcb7bf5412187a595ab97a3609160b5c6", Blockchain: "ethereum", Stablecoin: &notStable},
+		{Symbol: "UNI", Address: "0x1f9840a85d5af5bf1d1762f925bdaddc4201f984", Blockchain: "polygon"},
+	}
+	assets := assetsFor([]Blockchain{arbitrum, bitcoin, ethereum, bsc}, tokens)
+	var keys []string
+	for _, a := range assets {
+		keys = append(keys, a.Key)
+	}
+	if got, want := strings.Join(keys, ","), "ETH (arbitrum),BTC,ETH,BNB,USDT,LINK,USDT (bsc),USDD"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	stable := map[string]bool{}
+	for _, a := range assets {
+		stable[a.Key] = a.Stablecoin
+	}
+	if !stable["USDT"] || !stable["USDT (bsc)"] || stable["LINK"] || stable["USDD"] || stable["ETH"] {
+		t.Errorf("unexpected stablecoins %v", stable)
+	}
+
+	priced := priceAssets(assets, []Blockchain{{Name: "ethereum", Symbol: "ETH", Price: 3000}}, map[string]float64{
+		"0x514910771af9ca656af840dff83e8264ecf986ca": 14.5,
+	})
+	prices := map[string]float64{}
+	for _, a := range priced {
+		prices[a.Key] = a.Price
+	}
+	want := map[string]float64{"ETH (arbitrum)": 0, "BTC": 0, "ETH": 3000, "BNB": 0, "USDT": 1, "LINK": 14.5, "USDT (bsc)": 1, "USDD": 0}
+	for key, price := range want {
+		if prices[key] != price {
+			t.Errorf("%s: got %v, want %v", key, prices[key], price)
+		}
+	}
+}
+
+func TestSummarizeAssets(t *testing.T) {
+	assets := []Asset{
+		{Key: "ETH", Symbol: "ETH", Price: 2000},
+		{Key: "LINK", Symbol: "LINK", Price: 10},
+		{Key: "USDT", Symbol: "USDT", Price: 1, Stablecoin: true},
+	}
+	points := []Point{
+		{Date: 1, Assets: map[string]Series{
+			"ETH":  {Exchange: 100, DiamondHands: 100},
+			"LINK": {Exchange: 1000, DiamondHands: 1000},
+			"USDT": {Exchange: 1000000, DiamondHands: 5000000},
+		}},
+		{Date: 2, Assets: map[string]Series{
+			"ETH":  {Exchange: 90, DiamondHands: 130},
+			"LINK": {Exchange: 1100, DiamondHands: 700},
+			// cold wallets of stablecoins are ignored
+			"USDT": {Exchange: 1500000, DiamondHands: 1000000},
+		}},
+	}
+	got := summarize(points, assets)
+	// eth +40 * 2000, link -400 * 10, usdt +500000
+	for _, want := range []string{"*1h*: *+$576.00K*", "`ETH`: *+9.52%*", "`LINK`: `-10.53%`", "`USDT`: *+40.00%*"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q does not contain %q", got, want)
+		}
+	}
+}
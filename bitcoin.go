@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// bitcoindSource reads balances of known addresses from the utxo set of a bitcoin core node
+type bitcoindSource struct {
+	rpc *rpcClient
+}
+
+type scanResult struct {
+	Success  bool  `json:"success"`
+	Height   int64 `json:"height"`
+	Unspents []struct {
+		Desc   string      `json:"desc"`
+		Amount json.Number `json:"amount"`
+	} `json:"unspents"`
+}
+
+func newBitcoindSource(config RPCConfig, f *Fetcher) bitcoindSource {
+	// scanning the utxo set takes minutes
+	node := *f
+	node.Timeout = 10 * time.Minute
+	// a scan that ran out of time would run out of time again
+	node.NoRetryTimeouts = true
+	if config.TimeoutSeconds > 0 {
+		node.Timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	return bitcoindSource{newRPCClient(config, &node, "1.0")}
+}
+
+func (bitcoindSource) Source() string { return "bitcoind" }
+func (bitcoindSource) Chain() string  { return "bitcoin" }
+func (bitcoindSource) Symbol() string { return "BTC" }
+
+func (s bitcoindSource) Balances(ctx context.Context, whales []Wallet) ([]Wallet, error) {
+	if len(whales) == 0 {
+		return nil, nil
+	}
+	var descriptors []map[string]string
+	for _, whale := range whales {
+		descriptors = append(descriptors, map[string]string{"desc": "addr(" + whale.Address + ")"})
+	}
+	var result scanResult
+	// every address in one scan since each scan reads the whole utxo set
+	err := s.rpc.call(ctx, "scantxoutset", []interface{}{"start", descriptors}, &result)
+	if err != nil {
+		return nil, err
+	}
+	// sum in satoshis to avoid float errors
+	satoshis := map[string]int64{}
+	for _, u := range result.Unspents {
+		amount, err := u.Amount.Float64()
+		if err != nil {
+			return nil, err
+		}
+		satoshis[descriptorAddress(u.Desc)] += int64(math.Round(amount * 1e8))
+	}
+	var wallets []Wallet
+	for _, whale := range whales {
+		whale.Symbol = s.Symbol()
+		whale.Balance = float64(satoshis[whale.Address]) / 1e8
+		whale.BlockHeight = result.Height
+		wallets = append(wallets, whale)
+	}
+	return wallets, nil
+}
+
+// descriptorAddress reads the address of a descriptor like addr(bc1...)#checksum
+func descriptorAddress(desc string) string {
+	desc = strings.Split(desc, "#")[0]
+	desc = strings.TrimPrefix(desc, "addr(")
+	return strings.TrimSuffix(desc, ")")
+}
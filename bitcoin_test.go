@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBitcoind answers scantxoutset with the given unspents
+func fakeBitcoind(t *testing.T, unspents string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "whale" || password != "watcher" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != "scantxoutset" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"result":null,"error":{"code":-32601,"message":"Method not found"},"id":1}`))
+			return
+		}
+		if string(req.Params[0]) != `"start"` || !strings.Contains(string(req.Params[1]), `{"desc":"addr(1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ)"}`) {
+			t.Errorf("unexpected params %s", req.Params)
+		}
+		w.Write([]byte(`{"result":{"success":true,"height":720000,"unspents":` + unspents + `},"error":null,"id":1}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBitcoindBalances(t *testing.T) {
+	server := fakeBitcoind(t, `[
+		{"txid":"a","vout":0,"desc":"addr(34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo)#8d2jqaqs","amount":248597.1,"height":719000},
+		{"txid":"b","vout":1,"desc":"addr(34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo)#8d2jqaqs","amount":0.2,"height":719500},
+		{"txid":"c","vout":0,"desc":"addr(1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ)#ql6wdk0f","amount":126330.00000001,"height":700000}
+	]`)
+	config := RPCConfig{URL: server.URL, User: "whale", Password: "watcher"}
+	src := newBitcoindSource(config, testFetcher(server))
+	whales := []Wallet{
+		{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet", OwnerType: "exchange"},
+		{Blockchain: "bitcoin", Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ", OwnerType: "unknown"},
+		{Blockchain: "bitcoin", Address: "1LdRcdxfbSnmCYYNdeYpUnztiYzVfBEQeC", OwnerType: "unknown"},
+	}
+	wallets, err := src.Balances(context.Background(), whales)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet", Balance: 248597.3, OwnerType: "exchange", BlockHeight: 720000},
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ", Balance: 126330.00000001, OwnerType: "unknown", BlockHeight: 720000},
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "1LdRcdxfbSnmCYYNdeYpUnztiYzVfBEQeC", OwnerType: "unknown", BlockHeight: 720000},
+	})
+}
+
+func TestBitcoindErrors(t *testing.T) {
+	server := fakeBitcoind(t, `[]`)
+	whales := []Wallet{{Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ"}}
+
+	src := newBitcoindSource(RPCConfig{URL: server.URL, User: "whale", Password: "wrong"}, testFetcher(server))
+	_, err := src.Balances(context.Background(), whales)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want unauthorized", err)
+	}
+
+	rpc := newRPCClient(RPCConfig{URL: server.URL, User: "whale", Password: "watcher"}, testFetcher(server), "1.0")
+	err = rpc.call(context.Background(), "getblockcount", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "Method not found") {
+		t.Errorf("got %v, want method not found", err)
+	}
+}
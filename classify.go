@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// owner types the series understand
+var ownerTypes = []string{"exchange", "contract", "unknown", "stake", "wrap", "burn"}
+
+// Rule assigns an owner type to the wallets it matches. Empty fields match any wallet
+type Rule struct {
+	OwnerType  string `json:"owner_type"`
+	Blockchain string `json:"blockchain,omitempty"`
+	// Name is a regular expression matched against the owner name. eg. (?i)binance
+	Name      string   `json:"name,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	// Contract matches only contracts if true and only other wallets if false
+	Contract *bool `json:"contract,omitempty"`
+}
+
+// Label is what is known about a single address
+type Label struct {
+	Blockchain string
+	Address    string
+	Owner      string
+	// OwnerType is empty if only the owner is known
+	OwnerType string
+	// Source is the dataset the label was imported from
+	Source     string
+	Confidence float64
+}
+
+type compiledRule struct {
+	Rule
+	name      *regexp.Regexp
+	addresses map[string]bool
+}
+
+// Classifier sets the owner type of scraped wallets.
+// Labels are checked first, then rules in order, then the type is guessed.
+// Types curated in whale_override win over all of these when saved
+type Classifier struct {
+	labels   map[string]Label
+	rules    []compiledRule
+	entities entityNamer
+}
+
+func newClassifier(rules []Rule, labels []Label) (Classifier, error) {
+	var c Classifier
+	for i, r := range rules {
+		if !knownOwnerType(r.OwnerType) {
+			return c, fmt.Errorf("rule %d: unknown owner type %q", i, r.OwnerType)
+		}
+		compiled := compiledRule{Rule: r, addresses: map[string]bool{}}
+		if r.Name != "" {
+			name, err := regexp.Compile(r.Name)
+			if err != nil {
+				return c, fmt.Errorf("rule %d: %w", i, err)
+			}
+			compiled.name = name
+		}
+		for _, address := range r.Addresses {
+			compiled.addresses[strings.ToLower(address)] = true
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	return c.withLabels(labels)
+}
+
+// withLabels is the classifier with only these labels
+func (c Classifier) withLabels(labels []Label) (Classifier, error) {
+	c.labels = map[string]Label{}
+	for _, l := range labels {
+		if l.OwnerType != "" && !knownOwnerType(l.OwnerType) {
+			return c, fmt.Errorf("label %s: unknown owner type %q", l.Address, l.OwnerType)
+		}
+		c.labels[labelKey(l.Blockchain, l.Address)] = l
+	}
+	return c, nil
+}
+
+// labelled is the classifier with the labels of the wallets about to be classified.
+// The label table can hold millions of addresses so only those are read
+func (c Classifier) labelled(ctx context.Context, pool *pgxpool.Pool, wallets []Wallet) (Classifier, error) {
+	labels, err := loadLabels(ctx, pool, wallets)
+	if err != nil {
+		return c, err
+	}
+	return c.withLabels(labels)
+}
+
+// loadLabels reads the labels of the addresses of wallets
+func loadLabels(ctx context.Context, pool *pgxpool.Pool, wallets []Wallet) ([]Label, error) {
+	byChain := map[string][]string{}
+	for _, w := range wallets {
+		// imported labels are normalized. others are matched as they are
+		byChain[w.Blockchain] = append(byChain[w.Blockchain], w.Address, normalizeAddress(w.Address))
+	}
+	var labels []Label
+	for blockchain, addresses := range byChain {
+		rows, err := pool.Query(ctx, `
+			SELECT blockchain, address, coalesce(owner, ''), coalesce(owner_type, ''), source, confidence
+			FROM label
+			WHERE blockchain = $1
+			AND address = ANY($2);
+		`, blockchain, addresses)
+		if err != nil {
+			return nil, fmt.Errorf("label query error: %w", err)
+		}
+		for rows.Next() {
+			var l Label
+			err := rows.Scan(&l.Blockchain, &l.Address, &l.Owner, &l.OwnerType, &l.Source, &l.Confidence)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("label scan error: %w", err)
+			}
+			labels = append(labels, l)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, fmt.Errorf("label query error: %w", rows.Err())
+		}
+	}
+	return labels, nil
+}
+
+func knownOwnerType(ownerType string) bool {
+	for _, t := range ownerTypes {
+		if t == ownerType {
+			return true
+		}
+	}
+	return false
+}
+
+func labelKey(blockchain, address string) string {
+	return blockchain + ":" + strings.ToLower(address)
+}
+
+func (r compiledRule) matches(wallet Wallet) bool {
+	if r.Blockchain != "" && r.Blockchain != wallet.Blockchain {
+		return false
+	}
+	if r.Contract != nil && *r.Contract != wallet.IsContract {
+		return false
+	}
+	if r.name != nil && !r.name.MatchString(wallet.Name) {
+		return false
+	}
+	if len(r.addresses) > 0 && !r.addresses[strings.ToLower(wallet.Address)] {
+		return false
+	}
+	return true
+}
+
+// classify names the wallet from its label if it has no name, sets its owner type
+// and groups it under the entity that owns it
+func (c Classifier) classify(wallet Wallet) Wallet {
+	wallet = c.typed(wallet)
+	if wallet.OwnerType != "unknown" {
+		wallet.Entity = c.entities.entity(wallet.Name)
+	}
+	return wallet
+}
+
+func (c Classifier) typed(wallet Wallet) Wallet {
+	// the guess is from the scraped name. a label naming the owner doesn't make it an exchange
+	guess := ownerType(wallet)
+	if l, ok := c.labels[labelKey(wallet.Blockchain, wallet.Address)]; ok {
+		if wallet.Name == "" {
+			wallet.Name = l.Owner
+		}
+		if l.OwnerType != "" {
+			wallet.OwnerType = l.OwnerType
+			return wallet
+		}
+	}
+	for _, r := range c.rules {
+		if r.matches(wallet) {
+			wallet.OwnerType = r.OwnerType
+			return wallet
+		}
+	}
+	if wallet.OwnerType == "" {
+		wallet.OwnerType = guess
+	}
+	return wallet
+}
+
+func (c Classifier) classifyAll(wallets []Wallet) []Wallet {
+	for i := range wallets {
+		wallets[i] = c.classify(wallets[i])
+	}
+	return wallets
+}
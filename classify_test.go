@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	contract := true
+	classifier, err := newClassifier([]Rule{
+		{OwnerType: "stake", Blockchain: "ethereum", Addresses: []string{"0x00000000219ab540356cBB839Cbe05303d7705Fa"}},
+		{OwnerType: "wrap", Name: `^Wrapped`, Contract: &contract},
+		{OwnerType: "unknown", Name: `(?i)^fake_phishing`},
+	}, []Label{
+		{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Owner: "Binance-coldwallet", OwnerType: "exchange"},
+		{Blockchain: "ethereum", Address: "0xdead000000000000000042069420694206942069", OwnerType: "burn"},
+		{Blockchain: "ethereum", Address: "0x5754284f345afc66a98fbb0a0afe71e0f007b949", Owner: "Tether: Treasury"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		wallet Wallet
+		owner  string
+		want   string
+	}{
+		{Wallet{Blockchain: "ethereum", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2 Deposit Contract", IsContract: true}, "Eth2 Deposit Contract", "stake"},
+		// same address on another chain
+		{Wallet{Blockchain: "bsc", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", IsContract: true}, "", "contract"},
+		{Wallet{Blockchain: "ethereum", Address: "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2", Name: "Wrapped Ether", IsContract: true}, "Wrapped Ether", "wrap"},
+		{Wallet{Blockchain: "ethereum", Address: "0x1", Name: "Wrapped Ether"}, "Wrapped Ether", "exchange"},
+		{Wallet{Blockchain: "ethereum", Address: "0x2", Name: "Fake_Phishing5169"}, "Fake_Phishing5169", "unknown"},
+		{Wallet{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo"}, "Binance-coldwallet", "exchange"},
+		{Wallet{Blockchain: "ethereum", Address: "0xdEAD000000000000000042069420694206942069", IsContract: true}, "", "burn"},
+		// a labelled owner without a type keeps the type it had
+		{Wallet{Blockchain: "ethereum", Address: "0x5754284F345afc66a98fbB0a0Afe71e0F007B949"}, "Tether: Treasury", "unknown"},
+		{Wallet{Blockchain: "ethereum", Address: "0x5754284F345afc66a98fbB0a0Afe71e0F007B949", OwnerType: "exchange"}, "Tether: Treasury", "exchange"},
+		{Wallet{Blockchain: "bitcoin", Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ"}, "", "unknown"},
+	}
+	for _, tt := range tests {
+		got := classifier.classify(tt.wallet)
+		if got.Name != tt.owner || got.OwnerType != tt.want {
+			t.Errorf("%s %s: got %q %s, want %q %s", tt.wallet.Blockchain, tt.wallet.Address, got.Name, got.OwnerType, tt.owner, tt.want)
+		}
+	}
+}
+
+func TestClassifierErrors(t *testing.T) {
+	_, err := newClassifier([]Rule{{OwnerType: "whale"}}, nil)
+	if err == nil {
+		t.Error("expected unknown owner type error")
+	}
+	_, err = newClassifier([]Rule{{OwnerType: "exchange", Name: "("}}, nil)
+	if err == nil {
+		t.Error("expected invalid name pattern error")
+	}
+	_, err = newClassifier(nil, []Label{{Blockchain: "ethereum", Address: "0x1", OwnerType: "whale"}})
+	if err == nil {
+		t.Error("expected unknown label owner type error")
+	}
+}
+
+func TestLabelled(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, `
+		INSERT INTO label (blockchain, address, owner, owner_type) VALUES
+		('ethereum', '0xbe0eb53f46cd790cd13851d5eff43d12404d33e8', 'Binance 7', 'exchange'),
+		('bitcoin', '34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo', 'Binance-coldwallet', 'exchange'),
+		('bitcoin', '1FeexV6bAHb8ybZjqQMjJrcCrHGW9sb6uF', 'unknown whale', NULL);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classifier, err := newClassifier(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets := []Wallet{
+		// hex addresses match in any case
+		{Blockchain: "ethereum", Address: "0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8"},
+		{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo"},
+	}
+	labelled, err := classifier.labelled(ctx, pool, wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the labels of the wallets are read
+	if len(labelled.labels) != 2 {
+		t.Errorf("got labels %v, want the 2 of the wallets", labelled.labels)
+	}
+	for _, w := range labelled.classifyAll(wallets) {
+		if w.OwnerType != "exchange" || w.Name == "" {
+			t.Errorf("got %+v, want a named exchange", w)
+		}
+	}
+}
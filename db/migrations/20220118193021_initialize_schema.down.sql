@@ -1,4 +1,4 @@
-DROP FUNCTION trigger_set_updated;
-DROP TRIGGER set_timestamp;
+DROP TRIGGER IF EXISTS set_timestamp ON whale;
+DROP FUNCTION IF EXISTS trigger_set_updated;
 DROP TABLE IF EXISTS balance;
-DROP TABLE IF EXISTS whale;
\ No newline at end of file
+DROP TABLE IF EXISTS whale;
@@ -0,0 +1,15 @@
+CREATE TABLE scrape_health (
+	scrape_health_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	source varchar(32) NOT NULL,
+	blockchain varchar(16) NOT NULL,
+	symbol varchar(8) NOT NULL,
+	page_count int NOT NULL,
+	row_count int NOT NULL,
+	parsed_count int NOT NULL,
+	wallet_count int NOT NULL,
+	healthy bool NOT NULL,
+	detail jsonb NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX scrape_health_created_at_idx ON scrape_health USING btree (created_at);
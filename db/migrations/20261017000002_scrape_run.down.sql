@@ -0,0 +1,3 @@
+ALTER TABLE scrape_health DROP COLUMN IF EXISTS run_id;
+ALTER TABLE balance DROP COLUMN IF EXISTS run_id;
+DROP TABLE IF EXISTS scrape_run;
@@ -0,0 +1,40 @@
+CREATE TABLE scrape_run (
+	run_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	source varchar(32) NOT NULL,
+	blockchain varchar(16) NOT NULL,
+	symbol varchar(8) NOT NULL,
+	-- shared by every run of the same batch update so series line up across assets
+	batch_at timestamptz NOT NULL,
+	started_at timestamptz NOT NULL DEFAULT NOW(),
+	finished_at timestamptz,
+	status varchar(16) NOT NULL DEFAULT 'running',
+	page_count int NOT NULL DEFAULT 0,
+	row_count int NOT NULL DEFAULT 0,
+	archive_id varchar(128)
+);
+
+CREATE INDEX scrape_run_batch_at_idx ON scrape_run USING btree (batch_at);
+CREATE INDEX scrape_run_status_idx ON scrape_run USING btree (status);
+
+-- existing balances were grouped by hour
+INSERT INTO scrape_run (source, blockchain, symbol, batch_at, started_at, finished_at, status, row_count)
+SELECT 'legacy', w.blockchain, b.symbol, date_trunc('hour', b.created_at), min(b.created_at), max(b.created_at), 'success', count(*)
+FROM balance b
+JOIN whale w USING (whale_id)
+GROUP BY w.blockchain, b.symbol, date_trunc('hour', b.created_at);
+
+ALTER TABLE balance ADD COLUMN run_id int REFERENCES scrape_run(run_id);
+
+UPDATE balance b
+SET run_id = r.run_id
+FROM whale w, scrape_run r
+WHERE b.whale_id = w.whale_id
+AND r.source = 'legacy'
+AND r.blockchain = w.blockchain
+AND r.symbol = b.symbol
+AND r.batch_at = date_trunc('hour', b.created_at);
+
+ALTER TABLE balance ALTER COLUMN run_id SET NOT NULL;
+CREATE INDEX run_id_idx ON balance USING btree (run_id);
+
+ALTER TABLE scrape_health ADD COLUMN run_id int REFERENCES scrape_run(run_id);
@@ -0,0 +1 @@
+ALTER TABLE balance DROP COLUMN IF EXISTS block_height;
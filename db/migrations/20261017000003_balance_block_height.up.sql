@@ -0,0 +1,2 @@
+-- block the balance was read at when read from a node
+ALTER TABLE balance ADD COLUMN block_height bigint;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS token_supply;
+DROP TABLE IF EXISTS token;
+
+ALTER TABLE balance DROP COLUMN IF EXISTS percentage;
+
+-- fails if longer symbols were saved
+ALTER TABLE scrape_health ALTER COLUMN symbol TYPE varchar(8);
+ALTER TABLE scrape_run ALTER COLUMN symbol TYPE varchar(8);
+ALTER TABLE balance ALTER COLUMN symbol TYPE varchar(8);
@@ -0,0 +1,31 @@
+ALTER TABLE balance ALTER COLUMN symbol TYPE varchar(32);
+ALTER TABLE scrape_run ALTER COLUMN symbol TYPE varchar(32);
+ALTER TABLE scrape_health ALTER COLUMN symbol TYPE varchar(32);
+
+-- share of the token supply held
+ALTER TABLE balance ADD COLUMN percentage numeric;
+
+CREATE TABLE token (
+	token_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	blockchain varchar(16) NOT NULL,
+	address varchar(64) NOT NULL,
+	symbol varchar(32) NOT NULL,
+	name text NULL,
+	decimals int NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	updated_at timestamptz,
+	CONSTRAINT ux_token_blockchain_address UNIQUE (blockchain, address)
+);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON token
+FOR EACH ROW EXECUTE FUNCTION trigger_set_updated();
+
+CREATE TABLE token_supply (
+	token_supply_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	token_id int NOT NULL REFERENCES token(token_id),
+	total_supply numeric NOT NULL,
+	batch_at timestamptz NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	CONSTRAINT ux_token_supply_batch UNIQUE (token_id, batch_at)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS whale_override;
+DROP TABLE IF EXISTS label;
@@ -0,0 +1,51 @@
+-- known owners of addresses used to classify scraped wallets
+CREATE TABLE label (
+	label_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	blockchain varchar(16) NOT NULL,
+	address varchar(64) NOT NULL,
+	owner varchar(64) NULL,
+	owner_type varchar(32) NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	updated_at timestamptz,
+	CONSTRAINT ux_label_blockchain_address UNIQUE (blockchain, address)
+);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON label
+FOR EACH ROW EXECUTE FUNCTION trigger_set_updated();
+
+-- manually curated whales. scrapes never replace these
+CREATE TABLE whale_override (
+	whale_override_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	blockchain varchar(16) NOT NULL,
+	address varchar(64) NOT NULL,
+	owner varchar(64) NULL,
+	owner_type varchar(32) NULL,
+	note text NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	updated_at timestamptz,
+	CONSTRAINT ux_whale_override_blockchain_address UNIQUE (blockchain, address)
+);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON whale_override
+FOR EACH ROW EXECUTE FUNCTION trigger_set_updated();
+
+-- scrapes never set these types so they were edited by hand. keep them
+INSERT INTO whale_override (blockchain, address, owner_type, note)
+SELECT blockchain, address, owner_type, 'edited before overrides existed'
+FROM whale
+WHERE owner_type IN ('stake', 'wrap', 'burn');
+
+-- any other type that differs from what a scrape would guess was either edited by hand
+-- or drifted when an owner was named later. keep them all so no edit is lost.
+-- delete the ones that only drifted and the next scrape guesses them again
+INSERT INTO whale_override (blockchain, address, owner_type, note)
+SELECT blockchain, address, owner_type, 'differed from the guess before overrides existed'
+FROM whale
+WHERE owner_type NOT IN ('stake', 'wrap', 'burn')
+AND owner_type IS DISTINCT FROM CASE
+	WHEN is_contract THEN 'contract'
+	WHEN owner IS NOT NULL THEN 'exchange'
+	ELSE 'unknown'
+END;
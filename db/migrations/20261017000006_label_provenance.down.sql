@@ -0,0 +1,4 @@
+ALTER TABLE whale DROP COLUMN IF EXISTS label_id;
+DROP INDEX IF EXISTS label_address_idx;
+ALTER TABLE label DROP COLUMN IF EXISTS confidence;
+ALTER TABLE label DROP COLUMN IF EXISTS source;
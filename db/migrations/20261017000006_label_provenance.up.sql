@@ -0,0 +1,8 @@
+-- dataset a label was imported from and how much it is trusted from 0 to 1
+ALTER TABLE label ADD COLUMN source varchar(64) NOT NULL DEFAULT 'manual';
+ALTER TABLE label ADD COLUMN confidence numeric NOT NULL DEFAULT 1;
+
+CREATE INDEX label_address_idx ON label USING btree (blockchain, lower(address));
+
+-- label the owner and type of a whale came from
+ALTER TABLE whale ADD COLUMN label_id int NULL REFERENCES label(label_id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS entity_id_idx;
+ALTER TABLE label DROP COLUMN IF EXISTS entity_id;
+ALTER TABLE whale DROP COLUMN IF EXISTS entity_id;
+DROP TABLE IF EXISTS entity;
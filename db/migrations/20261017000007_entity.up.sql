@@ -0,0 +1,12 @@
+-- owner of many wallets across chains. eg. Binance
+CREATE TABLE entity (
+	entity_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	name varchar(64) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	CONSTRAINT ux_entity_name UNIQUE (name)
+);
+
+ALTER TABLE whale ADD COLUMN entity_id int NULL REFERENCES entity(entity_id);
+ALTER TABLE label ADD COLUMN entity_id int NULL REFERENCES entity(entity_id);
+
+CREATE INDEX entity_id_idx ON whale USING btree (entity_id);
@@ -0,0 +1,12 @@
+-- moves between tracked whales inferred from equal and opposite balance changes
+CREATE TABLE transfer (
+	transfer_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	-- run the receiving balance was captured in
+	run_id int NOT NULL REFERENCES scrape_run(run_id),
+	from_whale_id int NOT NULL REFERENCES whale(whale_id),
+	to_whale_id int NOT NULL REFERENCES whale(whale_id),
+	amount numeric NOT NULL,
+	confidence numeric NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	CONSTRAINT ux_transfer_run_whales UNIQUE (run_id, from_whale_id, to_whale_id)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS whale_membership;
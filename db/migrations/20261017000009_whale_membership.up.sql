@@ -0,0 +1,38 @@
+-- when a whale was on a rich list. exited_at is null while it still is
+CREATE TABLE whale_membership (
+	membership_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	whale_id int NOT NULL REFERENCES whale(whale_id),
+	blockchain varchar(16) NOT NULL,
+	source varchar(32) NOT NULL,
+	symbol varchar(32) NOT NULL,
+	entered_at timestamptz NOT NULL,
+	entered_run_id int NULL REFERENCES scrape_run(run_id),
+	exited_at timestamptz NULL,
+	exited_run_id int NULL REFERENCES scrape_run(run_id),
+	-- balance before it exited. carried forward in the series
+	last_value numeric NULL,
+	-- entered on the first run of the list
+	initial bool NOT NULL DEFAULT false,
+	created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX ux_whale_membership_open ON whale_membership (whale_id, source, symbol) WHERE exited_at IS NULL;
+CREATE INDEX whale_membership_list_idx ON whale_membership USING btree (blockchain, source, symbol);
+CREATE INDEX whale_membership_entered_run_idx ON whale_membership USING btree (entered_run_id);
+CREATE INDEX whale_membership_exited_run_idx ON whale_membership USING btree (exited_run_id);
+
+-- whales on the latest run of each list are members since they were first seen
+INSERT INTO whale_membership (whale_id, blockchain, source, symbol, entered_at, initial)
+SELECT b.whale_id, r.blockchain, r.source, r.symbol, (
+	SELECT min(r2.batch_at)
+	FROM balance b2
+	JOIN scrape_run r2 USING (run_id)
+	WHERE b2.whale_id = b.whale_id AND b2.symbol = r.symbol
+), true
+FROM (
+	SELECT DISTINCT ON (blockchain, symbol) run_id, blockchain, source, symbol
+	FROM scrape_run
+	WHERE status = 'success'
+	ORDER BY blockchain, symbol, batch_at DESC
+) r
+JOIN balance b ON b.run_id = r.run_id;
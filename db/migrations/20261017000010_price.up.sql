@@ -0,0 +1,11 @@
+-- usd price of each asset. saved for every batch and backfilled hourly from coingecko
+CREATE TABLE price (
+	price_id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	blockchain varchar(16) NOT NULL,
+	symbol varchar(32) NOT NULL,
+	-- batch the price is for, or the start of the hour of a backfilled price
+	bucket_at timestamptz NOT NULL,
+	usd numeric NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT NOW(),
+	CONSTRAINT ux_price_asset_bucket UNIQUE (blockchain, symbol, bucket_at)
+);
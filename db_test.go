@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// testPool migrates a schema of its own in the database at TEST_DATABASE_URL.
+// Tests of queries are skipped without one
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close(ctx)
+	})
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	// days are 24 hours
+	config.ConnConfig.RuntimeParams["timezone"] = "UTC"
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	err = migrate(ctx, pool, []string{"up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// testConn is a connection of the pool for queries that take one
+func testConn(t *testing.T, pool *pgxpool.Pool) *pgx.Conn {
+	t.Helper()
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Release)
+	return conn.Conn()
+}
+
+func insertWhale(t *testing.T, pool *pgxpool.Pool, blockchain, address, ownerType string, isContract bool) int {
+	t.Helper()
+	var id int
+	err := pool.QueryRow(context.Background(), `
+		INSERT INTO whale (blockchain, address, owner_type, is_contract)
+		VALUES ($1, $2, $3, $4)
+		RETURNING whale_id;
+	`, blockchain, address, ownerType, isContract).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// insertRun adds a successful run
+func insertRun(t *testing.T, pool *pgxpool.Pool, source, blockchain, symbol string, batchAt time.Time) Run {
+	t.Helper()
+	run := Run{Source: source, Chain: blockchain, Symbol: symbol, BatchAt: batchAt}
+	err := pool.QueryRow(context.Background(), `
+		INSERT INTO scrape_run (source, blockchain, symbol, batch_at, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING run_id;
+	`, source, blockchain, symbol, batchAt, runSuccess).Scan(&run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func insertBalance(t *testing.T, pool *pgxpool.Pool, run Run, whaleID int, value float64) {
+	t.Helper()
+	_, err := pool.Exec(context.Background(), `
+		INSERT INTO balance (whale_id, value, symbol, run_id, created_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`, whaleID, value, run.Symbol, run.ID, run.BatchAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,16 +1,17 @@
-function populateTable(series) {
-    function seriesDif(milestones, data, id, color) {
-        var snow = data[data.length - 1][1]
+function populateTable(series, changes) {
+    // changes are in coins so price moves don't look like whales buying or selling
+    function seriesDif(milestones, amounts, id, color) {
+        var snow = amounts[amounts.length - 1]
         var html = '<td class="border px-8">' + id + '</td>'
         milestones.forEach(milestone => {
             
             var value = ""
             var val
-            if (data.length < 1 + milestone) {
+            if (amounts.length < 1 + milestone) {
                 return
             }
-            var old = data[data.length - (1 + milestone)][1]
-            if (isNaN(old)) {
+            var old = amounts[amounts.length - (1 + milestone)]
+            if (missing(old) || missing(snow)) {
                 html += '<td class="border px-8"> </td>'
                 return
             }
@@ -33,44 +34,35 @@ function populateTable(series) {
             html += `<td class="border px-8 text-${textColor}"> ${value} </td>`
         
         })
-        document.getElementById(id).innerHTML = html
+        var row = document.createElement("tr")
+        row.id = id
+        row.innerHTML = html
+        document.getElementById("summary").appendChild(row)
     }
     var colors = Highcharts.getOptions().colors
     
     let milestones = [1,4,24,168,720]
     
-    analyze(series, milestones)
+    analyze(changes, milestones)
     for (i = 0; i < series.length; i++) {
-        if(document.getElementById(series[i].name) === null){
+        if(!series[i].table){
             continue
         }
-        seriesDif(milestones, series[i].data, series[i].name, colors[i])
+        seriesDif(milestones, series[i].amounts, series[i].name, colors[i])
     }
 }
 
+// a point is missing when its asset was not captured in that batch
+function missing(value) {
+    return value === null || value === undefined || isNaN(value)
+}
 
-
-function analyze(series, milestones) {
-    var series_index = [0,2,4,5,7]
+// changes are the overall usd changes computed with the telegram summary.
+// older outputs don't have them
+function analyze(changes, milestones) {
     for(var i=0; i<milestones.length; i++) {
-        var overall = 0
-        series_index.forEach(index => {
-            var group = series[index]
-            if (group.data.length >= 1 + milestones[i]) {
-                var old = group.data[group.data.length - (1 + milestones[i])]
-                if (old.length < 1 || isNaN(old[1])) {
-                    return
-                }
-                var snow = group.data[group.data.length - 1][1]
-                var value = snow - old[1]
-                
-                
-                if(group.name.includes("Exchange") && !group.name.includes("USD")) {
-                    value *= -1
-                }
-                overall += value
-            }
-        })
+        let change = (changes || []).find(change => change.hours == milestones[i])
+        let overall = change ? change.usd : 0
         var value = ""
         var color = ""
         let abs = Math.abs(overall)
@@ -95,111 +87,111 @@ function analyze(series, milestones) {
 }
 
 var date_options = { "day": "2-digit", "year": "numeric", "month": "short", "hour": "numeric" }
+
+// total balance of an asset in a point
+function total(series) {
+    return (series.diamond_hands || 0) + (series.paper_hands || 0) + (series.wrap || 0) + (series.stake || 0) + (series.exchange || 0)
+}
+
 function generateStats(whale, coingecko){
-    var btcprice = coingecko.bitcoin.usd
-    var ethprice = coingecko.ethereum.usd
-    let now = whale[whale.length-1]
-    let ethtotal = (now.eth.diamond_hands+now.eth.paper_hands+now.eth.wrap+now.eth.stake+now.eth.exchange)*ethprice
-    let btctotal = (now.btc.diamond_hands+now.btc.paper_hands+now.btc.exchange)*btcprice
-    var ethChange = ""
-    if(coingecko.ethereum.usd_24h_change > 0){
-        ethChange = ' <small style="color:green;">+'+Math.abs(coingecko.ethereum.usd_24h_change).toFixed(2)+'%</small>'
-    } else {
-        ethChange = ' <small style="color:red;">-'+Math.abs(coingecko.ethereum.usd_24h_change).toFixed(2)+'%</small>'
-    }
-    let ethCapture = '<br><small>Top 10,000 <a href="https://etherscan.io/accounts">wallets</a> own '+Highcharts.numberFormat(ethtotal/coingecko.ethereum.usd_market_cap*100, 2) + '% of marketcap</small>'
-    + '<br><small>Cold Wallets: '+(now.eth.diamond_hands*ethprice*100/ethtotal).toFixed(2)+'%</small>'
-    + '<br><small>Hot Wallets: '+(now.eth.paper_hands*ethprice*100/ethtotal).toFixed(2)+'%</small>'
-    + '<br><small>Exchanges: '+(now.eth.exchange*ethprice*100/ethtotal).toFixed(2)+'%</small>'
-    let btcCapture = '<br><small>Top 4,000 <a href="https://bitinfocharts.com/top-100-richest-bitcoin-addresses.html">wallets</a> own '+Highcharts.numberFormat(btctotal/coingecko.bitcoin.usd_market_cap*100, 2) + '% of marketcap</small>'
-    + '<br><small>Cold Wallets: '+(now.btc.diamond_hands*btcprice*100/btctotal).toFixed(2)+'%</small>'
-    + '<br><small>Hot Wallets: '+(now.btc.paper_hands*btcprice*100/btctotal).toFixed(2)+'%</small>'
-    + '<br><small>Exchanges: '+(now.btc.exchange*btcprice*100/btctotal).toFixed(2)+'%</small>'
-    var btcChange = ""
-    if(coingecko.bitcoin.usd_24h_change > 0){
-        btcChange = ' <small style="color:green;">+'+Math.abs(coingecko.bitcoin.usd_24h_change).toFixed(2)+'%</small>'
-    } else {
-        btcChange = ' <small style="color:red;">-'+Math.abs(coingecko.bitcoin.usd_24h_change).toFixed(2)+'%</small>'
-    }
-    document.getElementById("eth_stats").innerHTML = '<a href="https://www.coingecko.com/en/coins/ethereum">ETH</a>: $'+Highcharts.numberFormat(ethprice, 2)+ethChange+ethCapture
-    document.getElementById("btc_stats").innerHTML = '<a href="https://www.coingecko.com/en/coins/ethereum">BTC</a>: $'+Highcharts.numberFormat(btcprice, 2)+btcChange+btcCapture
+    let now = whale.points[whale.points.length-1]
+    let stats = document.getElementById("stats")
+    whale.assets.forEach(asset => {
+        let market = coingecko[asset.price_id]
+        let series = now.assets[asset.key]
+        if (!market || !series) {
+            return
+        }
+        let price = market.usd
+        let value = total(series) * price
+        var change = ""
+        if(market.usd_24h_change > 0){
+            change = ' <small style="color:green;">+'+Math.abs(market.usd_24h_change).toFixed(2)+'%</small>'
+        } else {
+            change = ' <small style="color:red;">-'+Math.abs(market.usd_24h_change).toFixed(2)+'%</small>'
+        }
+        let capture = '<br><small>Top wallets own '+Highcharts.numberFormat(value/market.usd_market_cap*100, 2) + '% of marketcap</small>'
+        + '<br><small>Cold Wallets: '+((series.diamond_hands || 0)*price*100/value).toFixed(2)+'%</small>'
+        + '<br><small>Hot Wallets: '+((series.paper_hands || 0)*price*100/value).toFixed(2)+'%</small>'
+        + '<br><small>Exchanges: '+((series.exchange || 0)*price*100/value).toFixed(2)+'%</small>'
+        let div = document.createElement("div")
+        div.innerHTML = '<p><a href="https://www.coingecko.com/en/coins/'+asset.price_id+'">'+asset.key+'</a>: $'+Highcharts.numberFormat(price, 2)+change+capture+'</p>'
+        stats.appendChild(div)
+    })
+    let stablecoins = whale.assets.filter(asset => asset.stablecoin).map(asset => asset.key)
+    document.getElementById("stablecoins").innerHTML = "* Only top wallets of " + stablecoins.join(", ") + " are counted"
     document.getElementById("last_updated").innerHTML = "Last updated: " + new Date(now.date*1000).toLocaleDateString("en-US", date_options)
 }
 
-function generateSeries(whale, coingecko) {
-    var series = [
-        {
-            type: 'line',
-            name: '[ETH] Cold Wallets',
-            data: [],
-            visible: false
-        },
-        {
-            type: 'line',
-            name: '[ETH] Hot Wallets',
-            data: [],
-            visible: false
-        },
-        {
-            type: 'line',
-            name: '[ETH] Exchanges',
-            data: [],
-            visible: true
-        },
-        {
-            type: 'line',
-            name: '[ETH] Staked',
-            data: [],
-            visible: false
-        },
-        {
-            type: 'line',
-            name: '[USD*] Exchanges',
-            data: [],
-            visible: true
-        },
-        {
-            type: 'line',
-            name: '[BTC] Cold Wallets',
-            data: [],
-            visible: false
-        },
-        {
-            type: 'line',
-            name: '[BTC] Hot Wallets',
-            data: [],
-            visible: false
-        },
-        {
-            type: 'line',
-            name: '[BTC] Exchanges',
-            data: [],
-            visible: true
-        },
+// lines of an asset
+function assetLines(asset, points) {
+    if (asset.stablecoin) {
+        return [{name: 'Exchanges', field: 'exchange', visible: true, table: true}]
+    }
+    let native = !asset.address
+    let lines = [
+        {name: 'Cold Wallets', field: 'diamond_hands', visible: false, table: native},
+        {name: 'Hot Wallets', field: 'paper_hands', visible: false},
+        {name: 'Exchanges', field: 'exchange', visible: native, table: native},
     ]
-    var btcprice = coingecko.bitcoin.usd
-    var ethprice = coingecko.ethereum.usd
-    whale.forEach(point => {
+    if (points.some(point => point.assets[asset.key] && point.assets[asset.key].stake)) {
+        lines.push({name: 'Staked', field: 'stake', visible: false, table: native})
+    }
+    return lines
+}
+
+function generateSeries(whale) {
+    var series = []
+    whale.assets.forEach(asset => {
+        assetLines(asset, whale.points).forEach(line => {
+            series.push({
+                type: 'line',
+                name: '[' + asset.key + '] ' + line.name,
+                data: [],
+                // coins of each point for the table
+                amounts: [],
+                visible: line.visible,
+                table: line.table,
+                asset: asset.key,
+                field: line.field,
+                price: asset.price
+            })
+        })
+    })
+    whale.points.forEach(point => {
         var date = point.date * 1000
-        series[0].data.push([date, point.eth.diamond_hands * ethprice])
-        series[1].data.push([date, point.eth.paper_hands * ethprice])
-        series[2].data.push([date, point.eth.exchange * ethprice])
-        series[3].data.push([date, point.eth.stake * ethprice])
-        series[4].data.push([date, point.usd.exchange])
-        series[5].data.push([date, point.btc.diamond_hands * btcprice])
-        series[6].data.push([date, point.btc.paper_hands * btcprice])
-        series[7].data.push([date, point.btc.exchange * btcprice])
+        series.forEach(line => {
+            let values = point.assets[line.asset]
+            if (!values) {
+                // leave a gap
+                line.data.push([date, null])
+                line.amounts.push(null)
+                return
+            }
+            let amount = values[line.field] || 0
+            // valued at the price of the batch. older points only have today's price
+            let price = values.price || line.price
+            line.data.push([date, amount * price])
+            line.amounts.push(amount)
+        })
     })
     return series
 }
+
+// the output versions this page can read
+const supported_versions = [2, 3, 4, 5]
+
 async function main() {
-    const [whaleResponse, coingeckoResponse] = await Promise.all([
-        fetch('https://enzosv.xyz/static/ethwhales.json'),
-        fetch('https://api.coingecko.com/api/v3/simple/price?ids=bitcoin%2Cethereum&vs_currencies=usd&include_market_cap=true&include_24hr_change=true')
-    ]);
+    const whaleResponse = await fetch('https://enzosv.xyz/static/ethwhales.json')
     const whale = await whaleResponse.json();
+    if (!supported_versions.includes(whale.version)) {
+        document.getElementById("last_updated").innerHTML = "Unsupported data version " + whale.version
+        return
+    }
+    let ids = whale.assets.filter(asset => asset.price_id).map(asset => asset.price_id)
+    const coingeckoResponse = await fetch('https://api.coingecko.com/api/v3/simple/price?ids='+encodeURIComponent(ids.join(","))+'&vs_currencies=usd&include_market_cap=true&include_24hr_change=true')
     const coingecko = await coingeckoResponse.json();
-    let series = generateSeries(whale, coingecko)
+    let series = generateSeries(whale)
     generateStats(whale, coingecko)
     Highcharts.chart('container', {
         title: {
@@ -223,7 +215,7 @@ async function main() {
         },
         series: series
     });
-    populateTable(series);
+    populateTable(series, whale.changes);
     fetch("https://api.alternative.me/fng/").then(response => response.json())
     .then(response => {
         let data = response.data[0]
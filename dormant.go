@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+type DormantConfig struct {
+	// Days a balance has to stay the same to be dormant. 0 to disable alerts
+	Days int `json:"days"`
+	// Threshold is the least change in percent of the dormant balance to alert on
+	Threshold float64 `json:"threshold"`
+	// MinUSD is the least change in usd to alert on
+	MinUSD float64 `json:"min_usd"`
+}
+
+// Awakening is a whale whose balance changed after staying the same for a while
+type Awakening struct {
+	Wallet Wallet
+	Asset  Asset
+	Before float64
+	After  float64
+	// Since is the first batch with the dormant balance
+	Since time.Time
+	// At is the batch where the balance changed
+	At time.Time
+}
+
+func (a Awakening) dormancy() time.Duration {
+	return a.At.Sub(a.Since)
+}
+
+func (a Awakening) change() float64 {
+	return a.After - a.Before
+}
+
+// dormantBalance is the previous balance of a whale and what it is in the latest batch
+type dormantBalance struct {
+	Wallet Wallet
+	Before float64
+	// After is nil when the whale is missing from the latest batch
+	After *float64
+	// Since is the first batch with the previous balance
+	Since time.Time
+	// At is the latest batch
+	At time.Time
+	// Smallest is the smallest balance in the latest batch
+	Smallest float64
+}
+
+// awakened is the awakening of a whale whose balance changed after at least days unchanged.
+// Scrapes drop empty wallets so a whale missing from the latest batch moved everything,
+// unless its balance was below the smallest one listed and it only fell off the list
+func awakened(b dormantBalance, asset Asset, days int) (Awakening, bool) {
+	after := 0.0
+	if b.After != nil {
+		after = *b.After
+	} else if b.Before < b.Smallest {
+		return Awakening{}, false
+	}
+	if after == b.Before || b.At.Sub(b.Since) < time.Duration(days)*24*time.Hour {
+		return Awakening{}, false
+	}
+	wallet := b.Wallet
+	wallet.Balance = after
+	return Awakening{Wallet: wallet, Asset: asset, Before: b.Before, After: after, Since: b.Since, At: b.At}, true
+}
+
+// findAwakenings compares the latest balance of every whale of an asset to its previous one
+// and returns those that changed after at least days unchanged
+func findAwakenings(ctx context.Context, pool *pgxpool.Pool, asset Asset, days int) ([]Awakening, error) {
+	rows, err := pool.Query(ctx, `
+		with latest_run as (
+			select run_id, batch_at from scrape_run
+			where blockchain = $2 and symbol = $1 and status = 'success'
+			order by batch_at desc
+			limit 1
+		), previous_run as (
+			select run_id from scrape_run
+			where blockchain = $2 and symbol = $1 and status = 'success'
+			and batch_at < (select batch_at from latest_run)
+			order by batch_at desc
+			limit 1
+		), latest as (
+			select b.whale_id, b.value
+			from balance b
+			join latest_run using(run_id)
+			union all
+			-- whales in the previous batch that are missing from the latest
+			select b.whale_id, null
+			from balance b
+			join previous_run using(run_id)
+			where b.whale_id not in (
+				select whale_id from balance join latest_run using(run_id)
+			)
+		), previous as (
+			select distinct on (b.whale_id) b.whale_id, b.value, r.batch_at
+			from balance b
+			join scrape_run r using(run_id)
+			join latest l using(whale_id)
+			where b.symbol = $1
+			and r.status = 'success'
+			and r.batch_at < (select batch_at from latest_run)
+			order by b.whale_id, r.batch_at desc
+		), dormant as (
+			select p.whale_id, p.value, l.value as latest, (
+				-- first batch after the balance last differed
+				select min(r.batch_at)
+				from balance b
+				join scrape_run r using(run_id)
+				where b.whale_id = p.whale_id
+				and b.symbol = $1
+				and r.status = 'success'
+				and r.batch_at > coalesce((
+					select max(r2.batch_at)
+					from balance b2
+					join scrape_run r2 using(run_id)
+					where b2.whale_id = p.whale_id
+					and b2.symbol = $1
+					and r2.status = 'success'
+					and r2.batch_at < p.batch_at
+					and b2.value <> p.value
+				), '-infinity')
+			) as since
+			from previous p
+			join latest l using(whale_id)
+			where l.value is null or p.value <> l.value
+		)
+		select
+			w.address, coalesce(w.owner, ''), w.owner_type, d.value, d.latest, d.since, r.batch_at,
+			coalesce((select min(value) from balance join latest_run using(run_id)), 0)
+		from dormant d
+		join whale w using(whale_id)
+		cross join latest_run r
+		order by abs(coalesce(d.latest, 0) - d.value) desc;
+	`, asset.Symbol, asset.Blockchain.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s dormant query error: %w", asset.Key, err)
+	}
+	defer rows.Close()
+	var awakenings []Awakening
+	for rows.Next() {
+		b := dormantBalance{Wallet: Wallet{Blockchain: asset.Blockchain.Name, Symbol: asset.Symbol}}
+		err := rows.Scan(&b.Wallet.Address, &b.Wallet.Name, &b.Wallet.OwnerType, &b.Before, &b.After, &b.Since, &b.At, &b.Smallest)
+		if err != nil {
+			return nil, fmt.Errorf("%s dormant scan error: %w", asset.Key, err)
+		}
+		if a, ok := awakened(b, asset, days); ok {
+			awakenings = append(awakenings, a)
+		}
+	}
+	return awakenings, rows.Err()
+}
+
+// significant keeps awakenings that moved at least the threshold of their balance and min usd
+func significant(awakenings []Awakening, config DormantConfig) []Awakening {
+	var kept []Awakening
+	for _, a := range awakenings {
+		change := math.Abs(a.change())
+		if a.Before > 0 && change*100/a.Before < config.Threshold {
+			continue
+		}
+		if change*a.Asset.Price < config.MinUSD {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// composeAwakeningMessage describes each awakening with its size in usd and how long it slept
+func composeAwakeningMessage(awakenings []Awakening) string {
+	if len(awakenings) == 0 {
+		return ""
+	}
+	p := message.NewPrinter(language.English)
+	lines := []string{"*Dormant whales moved*"}
+	for _, a := range awakenings {
+		owner := ""
+		if a.Wallet.Name != "" {
+			owner = fmt.Sprintf(" `%s`", a.Wallet.Name)
+		}
+		change := a.change()
+		lines = append(lines, fmt.Sprintf("`%s`%s: %s%s %s ($%s) after %s",
+			a.Wallet.Address, owner,
+			sign(change), compact(p, math.Abs(change)), a.Asset.Key,
+			compact(p, math.Abs(change)*a.Asset.Price),
+			formatDormancy(a.dormancy())))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatDormancy is in years past a year and in days before
+func formatDormancy(d time.Duration) string {
+	days := d.Hours() / 24
+	if days >= 365 {
+		return fmt.Sprintf("%.1f years", days/365)
+	}
+	return fmt.Sprintf("%.0f days", days)
+}
+
+// alertAwakenings sends a telegram message about dormant whales of any asset that moved
+func alertAwakenings(ctx context.Context, pool *pgxpool.Pool, config Config, assets []Asset) error {
+	if config.Dormant.Days <= 0 || config.Telegram.BotID == "" || config.Telegram.RecipientID == "" {
+		return nil
+	}
+	var awakenings []Awakening
+	for _, asset := range assets {
+		found, err := findAwakenings(ctx, pool, asset, config.Dormant.Days)
+		if err != nil {
+			return err
+		}
+		awakenings = append(awakenings, significant(found, config.Dormant)...)
+	}
+	msg := composeAwakeningMessage(awakenings)
+	if msg == "" {
+		return nil
+	}
+	return sendMessage(config.Telegram.BotID, config.Telegram.RecipientID, msg, false)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAwakenings(t *testing.T) {
+	btc := Asset{Key: "BTC", Symbol: "BTC", Blockchain: bitcoin, Price: 40000}
+	at := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	awakenings := []Awakening{
+		{Wallet: Wallet{Address: "1FeexV6bAHb8ybZjqQMjJrcCrHGW9sb6uF"}, Asset: btc, Before: 79957, After: 78957, Since: at.AddDate(-3, 0, -73), At: at},
+		{Wallet: Wallet{Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet"}, Asset: btc, Before: 1000, After: 1500, Since: at.AddDate(0, 0, -400), At: at},
+		// below the threshold
+		{Wallet: Wallet{Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ"}, Asset: btc, Before: 100000, After: 99990, Since: at.AddDate(-1, 0, 0), At: at},
+		// below min usd
+		{Wallet: Wallet{Address: "1LdRcdxfbSnmCYYNdeYpUnztiYzVfBEQeC"}, Asset: btc, Before: 2, After: 1, Since: at.AddDate(-1, 0, 0), At: at},
+	}
+	got := composeAwakeningMessage(significant(awakenings, DormantConfig{Days: 365, Threshold: 1, MinUSD: 100000}))
+	want := "*Dormant whales moved*\n" +
+		"`1FeexV6bAHb8ybZjqQMjJrcCrHGW9sb6uF`: -1.00K BTC ($40.00M) after 3.2 years\n" +
+		"`34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo` `Binance-coldwallet`: +500.00 BTC ($20.00M) after 1.1 years"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := formatDormancy(200 * 24 * time.Hour); got != "200 days" {
+		t.Errorf("got %q, want 200 days", got)
+	}
+	if got := composeAwakeningMessage(nil); got != "" {
+		t.Errorf("got %q for no awakenings", got)
+	}
+}
+
+func TestSignificantBoundary(t *testing.T) {
+	btc := Asset{Key: "BTC", Symbol: "BTC", Blockchain: bitcoin, Price: 1000}
+	config := DormantConfig{Days: 365, Threshold: 10, MinUSD: 1000}
+	awakenings := []Awakening{
+		// exactly the threshold and min usd
+		{Wallet: Wallet{Address: "exact"}, Asset: btc, Before: 10, After: 9},
+		{Wallet: Wallet{Address: "under threshold"}, Asset: btc, Before: 20, After: 18.5},
+		{Wallet: Wallet{Address: "under min usd"}, Asset: btc, Before: 5, After: 5.9},
+		// no earlier balance is always past the threshold
+		{Wallet: Wallet{Address: "empty"}, Asset: btc, Before: 0, After: 2},
+	}
+	var got []string
+	for _, a := range significant(awakenings, config) {
+		got = append(got, a.Wallet.Address)
+	}
+	if len(got) != 2 || got[0] != "exact" || got[1] != "empty" {
+		t.Errorf("got %v, want exact and empty", got)
+	}
+}
+
+func TestAwakened(t *testing.T) {
+	btc := Asset{Key: "BTC", Symbol: "BTC", Blockchain: bitcoin}
+	at := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	value := func(v float64) *float64 {
+		return &v
+	}
+	tests := []struct {
+		name    string
+		balance dormantBalance
+		after   float64
+		ok      bool
+	}{
+		{"exactly the days", dormantBalance{Before: 8, After: value(9), Since: at.Add(-48 * time.Hour), At: at}, 9, true},
+		{"an hour short", dormantBalance{Before: 8, After: value(9), Since: at.Add(-47 * time.Hour), At: at}, 0, false},
+		{"unchanged", dormantBalance{Before: 8, After: value(8), Since: at.Add(-72 * time.Hour), At: at}, 0, false},
+		{"emptied", dormantBalance{Before: 40, Since: at.Add(-72 * time.Hour), At: at, Smallest: 3}, 0, true},
+		{"emptied at the smallest listed", dormantBalance{Before: 3, Since: at.Add(-72 * time.Hour), At: at, Smallest: 3}, 0, true},
+		{"fell off the list", dormantBalance{Before: 1, Since: at.Add(-72 * time.Hour), At: at, Smallest: 3}, 0, false},
+		{"emptied too soon", dormantBalance{Before: 40, Since: at.Add(-time.Hour), At: at, Smallest: 3}, 0, false},
+	}
+	for _, tt := range tests {
+		a, ok := awakened(tt.balance, btc, 2)
+		if ok != tt.ok {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && (a.After != tt.after || a.Wallet.Balance != tt.after || a.Before != tt.balance.Before) {
+			t.Errorf("%s: got %+v, want %v -> %v", tt.name, a, tt.balance.Before, tt.after)
+		}
+	}
+}
+
+func TestFindAwakenings(t *testing.T) {
+	pool := testPool(t)
+	btc := Asset{Key: "BTC", Symbol: "BTC", Blockchain: bitcoin}
+	latest := time.Now().Truncate(time.Second).Add(-time.Hour)
+	times := []time.Time{
+		latest.Add(-72 * time.Hour),
+		// exactly the dormant days before the latest batch
+		latest.Add(-48 * time.Hour),
+		latest.Add(-47 * time.Hour),
+		latest,
+	}
+	var runs []Run
+	for _, at := range times {
+		runs = append(runs, insertRun(t, pool, "bitinfocharts", "bitcoin", "BTC", at))
+	}
+	histories := map[string][]float64{
+		// unchanged since its first balance
+		"old": {100, 100, 100, 50},
+		// dormant for exactly two days
+		"boundary": {7, 8, 8, 9},
+		// an hour short of two days
+		"recent": {7, 7, 8, 9},
+		"steady": {5, 5, 5, 5},
+		// first seen in the latest batch
+		"new": {0, 0, 0, 3},
+		// moved everything so it is missing from the latest batch
+		"emptied": {40, 40, 40, 0},
+		// below the smallest balance in the latest batch so it may have only fallen off the list
+		"dropped": {1, 1, 1, 0},
+	}
+	for address, values := range histories {
+		whaleID := insertWhale(t, pool, "bitcoin", address, "unknown", false)
+		for i, v := range values {
+			if v > 0 {
+				insertBalance(t, pool, runs[i], whaleID, v)
+			}
+		}
+	}
+	got, err := findAwakenings(context.Background(), pool, btc, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		address       string
+		before, after float64
+		since         time.Time
+	}{
+		{"old", 100, 50, times[0]},
+		{"emptied", 40, 0, times[0]},
+		{"boundary", 8, 9, times[1]},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i, w := range want {
+		a := got[i]
+		if a.Wallet.Address != w.address || a.Before != w.before || a.After != w.after || !a.Since.Equal(w.since) || !a.At.Equal(latest) {
+			t.Errorf("%d: got %s %v -> %v since %s at %s, want %+v", i, a.Wallet.Address, a.Before, a.After, a.Since, a.At, w)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// EntityRule groups every owner name matching Pattern under Name
+type EntityRule struct {
+	Name string `json:"name"`
+	// Pattern is a regular expression. eg. (?i)^(binance|bnb)
+	Pattern string `json:"pattern"`
+}
+
+type compiledEntityRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// entityNamer turns the many names an owner is scraped with into one entity
+type entityNamer struct {
+	rules []compiledEntityRule
+}
+
+// words that describe a wallet of an entity rather than the entity
+var walletWords = map[string]bool{
+	"wallet":     true,
+	"coldwallet": true,
+	"hotwallet":  true,
+	"cold":       true,
+	"hot":        true,
+	"deposit":    true,
+	"withdrawal": true,
+	"exchange":   true,
+	"reserve":    true,
+}
+
+func newEntityNamer(rules []EntityRule) (entityNamer, error) {
+	var n entityNamer
+	for i, r := range rules {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return n, fmt.Errorf("entity rule %d: %w", i, err)
+		}
+		n.rules = append(n.rules, compiledEntityRule{r.Name, pattern})
+	}
+	return n, nil
+}
+
+// entity names the owner of a wallet. Empty if the owner is unknown
+func (n entityNamer) entity(owner string) string {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return ""
+	}
+	for _, r := range n.rules {
+		if r.pattern.MatchString(owner) {
+			return r.name
+		}
+	}
+	return normalizeEntity(owner)
+}
+
+// normalizeEntity strips what tells wallets of an owner apart.
+// eg. Binance7, Binance-Coldwallet and Binance: Hot Wallet 6 are all Binance
+func normalizeEntity(owner string) string {
+	name := owner
+	if i := strings.Index(name, ":"); i > 0 {
+		name = name[:i]
+	}
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_' || unicode.IsSpace(r)
+	})
+	for len(words) > 1 {
+		last := words[len(words)-1]
+		if !walletWords[strings.ToLower(last)] && strings.TrimRightFunc(last, unicode.IsDigit) != "" {
+			break
+		}
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return owner
+	}
+	if trimmed := strings.TrimRightFunc(words[len(words)-1], unicode.IsDigit); trimmed != "" {
+		words[len(words)-1] = trimmed
+	}
+	return strings.Join(words, " ")
+}
+
+// generate_entity_totals sums the balances of an asset held by the exchange wallets of each entity keyed by batch then entity
+func generate_entity_totals(ctx context.Context, conn *pgx.Conn, blockchain, symbol, membership string, days int) (map[int64]map[string]float64, error) {
+	query := fmt.Sprintf(`
+	select
+		extract(epoch from r.batch_at)::bigint as epoch,
+		e.name,
+		sum(b.value)
+	from %s b
+	join scrape_run r using(run_id)
+	join whale w using(whale_id)
+	join entity e using(entity_id)
+	where r.batch_at > now() - make_interval(days => $3)
+	and r.status = 'success'
+	and w.owner_type = 'exchange'
+	and b.symbol = $1
+	and w.blockchain = $2
+	group by r.batch_at, e.name
+	;
+	`, balanceRows(membership))
+	rows, err := conn.Query(ctx, query, symbol, blockchain, days)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+	totals := map[int64]map[string]float64{}
+	for rows.Next() {
+		var date int64
+		var name string
+		var total float64
+		err := rows.Scan(&date, &name, &total)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if totals[date] == nil {
+			totals[date] = map[string]float64{}
+		}
+		totals[date][name] = total
+	}
+	return totals, rows.Err()
+}
+
+// entityFlows is how much of an asset each entity gained over the last hours, in native units
+// and in usd at the price of each batch it moved in.
+// An entity missing from a point held none of it in the tracked wallets.
+// nil if the asset wasn't captured at both ends
+func entityFlows(points []Point, asset Asset, hours int) (flows, usd map[string]float64) {
+	if len(points) < 1+hours {
+		return nil, nil
+	}
+	now, ok := points[len(points)-1].Assets[asset.Key]
+	if !ok {
+		return nil, nil
+	}
+	start, ok := points[len(points)-(1+hours)].Assets[asset.Key]
+	if !ok {
+		return nil, nil
+	}
+	flows, usd = changes(start.Entities, now.Entities, 1), map[string]float64{}
+	old := start
+	for i := len(points) - hours; i < len(points); i++ {
+		s, ok := points[i].Assets[asset.Key]
+		if !ok {
+			continue
+		}
+		for entity, change := range changes(old.Entities, s.Entities, seriesPrice(s, asset)) {
+			usd[entity] += change
+		}
+		old = s
+	}
+	return flows, usd
+}
+
+// changes is how much each entity gained from old to now multiplied by scale
+func changes(old, now map[string]float64, scale float64) map[string]float64 {
+	changed := map[string]float64{}
+	for entity, total := range now {
+		changed[entity] = (total - old[entity]) * scale
+	}
+	for entity, total := range old {
+		if _, ok := now[entity]; !ok {
+			changed[entity] = -total * scale
+		}
+	}
+	return changed
+}
+
+// exchangeFlow is the net flow of an exchange entity over a window
+type exchangeFlow struct {
+	entity string
+	usd    float64
+	// bullish is the usd of the flows that suggests buying. Negative for selling
+	bullish float64
+	// flows of each asset in native units in the order of assets
+	flows []float64
+}
+
+// summarizeExchanges lists the top exchanges by absolute net flow in usd for each window.
+// Flows are in bold when they suggest buying, like crypto leaving exchanges or stablecoins entering them
+func summarizeExchanges(points []Point, assets []Asset, top int) string {
+	if top <= 0 || len(points) < 1 {
+		return ""
+	}
+	p := message.NewPrinter(language.English)
+	var lines []string
+	for _, k := range milestoneKeys {
+		byEntity := map[string]*exchangeFlow{}
+		for i, asset := range assets {
+			flows, usd := entityFlows(points, asset, milestones[k])
+			for entity, flow := range flows {
+				if flow == 0 {
+					continue
+				}
+				f, ok := byEntity[entity]
+				if !ok {
+					f = &exchangeFlow{entity: entity, flows: make([]float64, len(assets))}
+					byEntity[entity] = f
+				}
+				f.flows[i] = flow
+				f.usd += usd[entity]
+				f.bullish += bullish(usd[entity], asset.Stablecoin)
+			}
+		}
+		if len(byEntity) == 0 {
+			continue
+		}
+		var flows []exchangeFlow
+		for _, f := range byEntity {
+			flows = append(flows, *f)
+		}
+		sort.Slice(flows, func(i, j int) bool {
+			a, b := math.Abs(flows[i].usd), math.Abs(flows[j].usd)
+			if a != b {
+				return a > b
+			}
+			return flows[i].entity < flows[j].entity
+		})
+		if len(flows) > top {
+			flows = flows[:top]
+		}
+		lines = append(lines, fmt.Sprintf("*%s exchanges*:", k))
+		for _, f := range flows {
+			var natives []string
+			for i, asset := range assets {
+				if f.flows[i] == 0 {
+					continue
+				}
+				natives = append(natives, fmt.Sprintf("%s%s %s", sign(f.flows[i]), compact(p, math.Abs(f.flows[i])), asset.Key))
+			}
+			usd := fmt.Sprintf("%s$%s", sign(f.usd), compact(p, math.Abs(f.usd)))
+			if f.bullish > 0 {
+				usd = "*" + usd + "*"
+			} else {
+				usd = "`" + usd + "`"
+			}
+			lines = append(lines, fmt.Sprintf("`%s`: %s (%s)", f.entity, usd, strings.Join(natives, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sign(value float64) string {
+	if value < 0 {
+		return "-"
+	}
+	return "+"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEntity(t *testing.T) {
+	namer, err := newEntityNamer([]EntityRule{{Name: "OKX", Pattern: `(?i)^ok(ex|x)`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"Binance7":              "Binance",
+		"Binance-Coldwallet":    "Binance",
+		"Binance: Hot Wallet 6": "Binance",
+		"Bitfinex-coldwallet":   "Bitfinex",
+		"Kraken 4":              "Kraken",
+		"Huobi_10":              "Huobi",
+		"FTX Exchange":          "FTX",
+		"Crypto.com 2":          "Crypto.com",
+		"Gate.io Deposit":       "Gate.io",
+		"Tether: Treasury":      "Tether",
+		"Eth2 Deposit Contract": "Eth2 Deposit Contract",
+		"OKEx3":                 "OKX",
+		"OKX: Hot Wallet":       "OKX",
+		"1inch":                 "1inch",
+		"  ":                    "",
+	}
+	for owner, want := range tests {
+		if got := namer.entity(owner); got != want {
+			t.Errorf("%q: got %q, want %q", owner, got, want)
+		}
+	}
+	_, err = newEntityNamer([]EntityRule{{Name: "OKX", Pattern: "("}})
+	if err == nil {
+		t.Error("expected invalid pattern error")
+	}
+}
+
+func TestClassifyEntity(t *testing.T) {
+	classifier, err := newClassifier(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := classifier.classifyAll([]Wallet{
+		{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet"},
+		{Blockchain: "ethereum", Address: "0xbe0eb53f46cd790cd13851d5eff43d12404d33e8", Name: "Binance 7"},
+		{Blockchain: "bitcoin", Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ"},
+	})
+	for i, want := range []string{"Binance", "Binance", ""} {
+		if got[i].Entity != want {
+			t.Errorf("%s: got entity %q, want %q", got[i].Address, got[i].Entity, want)
+		}
+	}
+}
+
+func TestEntityFlows(t *testing.T) {
+	btc := Asset{Key: "BTC", Symbol: "BTC", Price: 3}
+	eth := Asset{Key: "ETH", Symbol: "ETH", Price: 1}
+	points := []Point{
+		{Date: 3600, Assets: map[string]Series{"BTC": {Entities: map[string]float64{"Binance": 100, "Kraken": 50, "FTX": 10}, Price: 1}}},
+		{Date: 7200, Assets: map[string]Series{"BTC": {Entities: map[string]float64{"Binance": 120, "Kraken": 40}, Price: 2}}},
+		// no price so the current one is used
+		{Date: 10800, Assets: map[string]Series{"BTC": {Entities: map[string]float64{"Binance": 90, "Kraken": 40, "Gemini": 5}}}},
+	}
+	flows, usd := entityFlows(points, btc, 2)
+	want := map[string]float64{"Binance": -10, "Kraken": -10, "FTX": -10, "Gemini": 5}
+	if !reflect.DeepEqual(flows, want) {
+		t.Errorf("got %v, want %v", flows, want)
+	}
+	// each change at the price of its batch
+	wantUSD := map[string]float64{"Binance": 20*2 - 30*3, "Kraken": -10 * 2, "FTX": -10 * 2, "Gemini": 5 * 3}
+	if !reflect.DeepEqual(usd, wantUSD) {
+		t.Errorf("got usd %v, want %v", usd, wantUSD)
+	}
+	if flows, _ := entityFlows(points, btc, 3); flows != nil {
+		t.Errorf("got %v for too few points", flows)
+	}
+	if flows, _ := entityFlows(points, eth, 1); flows != nil {
+		t.Errorf("got %v for a missing asset", flows)
+	}
+}
+
+func TestSummarizeExchanges(t *testing.T) {
+	assets := []Asset{
+		{Key: "BTC", Symbol: "BTC", Price: 40000},
+		{Key: "ETH", Symbol: "ETH", Price: 3000},
+		{Key: "USDT", Symbol: "USDT", Price: 1, Stablecoin: true},
+	}
+	points := []Point{
+		{Date: 3600, Assets: map[string]Series{
+			"BTC":  {Entities: map[string]float64{"Binance": 1000, "Kraken": 500, "Gemini": 100}},
+			"ETH":  {Entities: map[string]float64{"Binance": 10000, "Kraken": 2000}},
+			"USDT": {Entities: map[string]float64{"Bitfinex": 1000000}},
+		}},
+		{Date: 7200, Assets: map[string]Series{
+			"BTC":  {Entities: map[string]float64{"Binance": 900, "Kraken": 550, "Gemini": 101}},
+			"ETH":  {Entities: map[string]float64{"Binance": 11000, "Kraken": 2000}},
+			"USDT": {Entities: map[string]float64{"Bitfinex": 4000000}},
+		}},
+	}
+	got := summarizeExchanges(points, assets, 3)
+	// bitfinex +3M usdt, binance -100 btc +1000 eth, kraken +50 btc, gemini +1 btc is cut.
+	// stablecoins entering exchanges suggest buying like crypto leaving them
+	want := "*1h exchanges*:\n`Bitfinex`: *+$3.00M* (+3.00M USDT)\n`Kraken`: `+$2.00M` (+50.00 BTC)\n`Binance`: *-$1.00M* (-100.00 BTC, +1.00K ETH)"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := summarizeExchanges(points, assets, 0); got != "" {
+		t.Errorf("got %q when disabled", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// function selectors of erc20 calls
+	balanceOfSelector   = "0x70a08231"
+	decimalsSelector    = "0x313ce567"
+	nameSelector        = "0x06fdde03"
+	totalSupplySelector = "0x18160ddd"
+	// calls per json-rpc batch
+	ethBatchSize = 100
+)
+
+// ethNode reads balances from an ethereum json-rpc endpoint.
+// Every read is at the block number seen on first use so a batch update is one consistent snapshot
+type ethNode struct {
+	rpc   *rpcClient
+	once  sync.Once
+	block int64
+	err   error
+}
+
+func newEthNode(config RPCConfig, f *Fetcher) *ethNode {
+	node := *f
+	if config.TimeoutSeconds > 0 {
+		node.Timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	return &ethNode{rpc: newRPCClient(config, &node, "2.0")}
+}
+
+func (n *ethNode) pinnedBlock(ctx context.Context) (int64, error) {
+	n.once.Do(func() {
+		var hex string
+		n.err = n.rpc.call(ctx, "eth_blockNumber", nil, &hex)
+		if n.err != nil {
+			return
+		}
+		var block *big.Int
+		block, n.err = parseQuantity(hex)
+		if n.err == nil {
+			n.block = block.Int64()
+		}
+	})
+	return n.block, n.err
+}
+
+// ethCall calls a contract at the pinned block
+func (n *ethNode) ethCall(ctx context.Context, to, data string) (string, error) {
+	block, err := n.pinnedBlock(ctx)
+	if err != nil {
+		return "", err
+	}
+	var hex string
+	err = n.rpc.call(ctx, "eth_call", []interface{}{
+		map[string]string{"to": to, "data": data},
+		toQuantity(block),
+	}, &hex)
+	return hex, err
+}
+
+// callEach sends one call per params in batches and returns the quantities in order
+func (n *ethNode) callEach(ctx context.Context, method string, params []interface{}) ([]*big.Int, error) {
+	results := make([]*big.Int, len(params))
+	for start := 0; start < len(params); start += ethBatchSize {
+		end := start + ethBatchSize
+		if end > len(params) {
+			end = len(params)
+		}
+		raw := make([]json.RawMessage, end-start)
+		err := n.rpc.batch(ctx, method, params[start:end], raw)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range raw {
+			var hex string
+			err = json.Unmarshal(r, &hex)
+			if err == nil {
+				results[start+i], err = parseQuantity(hex)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s result error: %w", method, err)
+			}
+		}
+	}
+	return results, nil
+}
+
+// ethSource reads the native balance of known whales
+type ethSource struct {
+	node   *ethNode
+	chain  string
+	symbol string
+}
+
+func (ethSource) Source() string   { return "rpc" }
+func (s ethSource) Chain() string  { return s.chain }
+func (s ethSource) Symbol() string { return s.symbol }
+
+func (s ethSource) Balances(ctx context.Context, whales []Wallet) ([]Wallet, error) {
+	block, err := s.node.pinnedBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var params []interface{}
+	for _, whale := range whales {
+		params = append(params, []interface{}{whale.Address, toQuantity(block)})
+	}
+	results, err := s.node.callEach(ctx, "eth_getBalance", params)
+	if err != nil {
+		return nil, err
+	}
+	return withBalances(whales, results, 18, s.symbol, block), nil
+}
+
+// erc20Source reads the token balance of known holders
+type erc20Source struct {
+	node  *ethNode
+	token TokenContract
+}
+
+func (erc20Source) Source() string   { return "rpc" }
+func (s erc20Source) Chain() string  { return s.token.Blockchain }
+func (s erc20Source) Symbol() string { return s.token.Symbol }
+
+func (s erc20Source) Balances(ctx context.Context, whales []Wallet) ([]Wallet, error) {
+	block, err := s.node.pinnedBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	decimals, err := readDecimals(ctx, s.node, s.token)
+	if err != nil {
+		return nil, err
+	}
+	var params []interface{}
+	for _, whale := range whales {
+		// abi encoded balanceOf(address)
+		data := balanceOfSelector + fmt.Sprintf("%064s", strings.TrimPrefix(strings.ToLower(whale.Address), "0x"))
+		params = append(params, []interface{}{
+			map[string]string{"to": s.token.Address, "data": data},
+			toQuantity(block),
+		})
+	}
+	results, err := s.node.callEach(ctx, "eth_call", params)
+	if err != nil {
+		return nil, err
+	}
+	return withBalances(whales, results, decimals, s.token.Symbol, block), nil
+}
+
+// withBalances sets the balance of each whale from amounts in the smallest unit
+func withBalances(whales []Wallet, amounts []*big.Int, decimals int, symbol string, block int64) []Wallet {
+	var wallets []Wallet
+	for i, whale := range whales {
+		whale.Balance = scale(amounts[i], decimals)
+		whale.Symbol = symbol
+		whale.BlockHeight = block
+		wallets = append(wallets, whale)
+	}
+	return wallets
+}
+
+// scale converts an amount in the smallest unit to whole tokens
+func scale(amount *big.Int, decimals int) float64 {
+	unit := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), unit).Float64()
+	return f
+}
+
+// parseQuantity reads a hex encoded number. Empty results like "0x" are 0
+func parseQuantity(hex string) (*big.Int, error) {
+	digits := strings.TrimLeft(strings.TrimPrefix(hex, "0x"), "0")
+	if digits == "" {
+		return new(big.Int), nil
+	}
+	n, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid quantity %q", hex)
+	}
+	return n, nil
+}
+
+func toQuantity(n int64) string {
+	return fmt.Sprintf("0x%x", n)
+}
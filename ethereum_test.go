@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeEthNode is at block 0xe4e1c0 and answers eth_getBalance with native balances
+// and eth_call with the metadata and balances of a usdt like token
+func fakeEthNode(t *testing.T, eth, tokens map[string]string) *httptest.Server {
+	t.Helper()
+	respond := func(req rpcRequest) rpcResponse {
+		var params []json.RawMessage
+		raw, _ := json.Marshal(req.Params)
+		json.Unmarshal(raw, &params)
+		res := rpcResponse{ID: req.ID}
+		var result string
+		switch req.Method {
+		case "eth_blockNumber":
+			result = "0xe4e1c0"
+		case "eth_getBalance":
+			var address string
+			json.Unmarshal(params[0], &address)
+			if string(params[1]) != `"0xe4e1c0"` {
+				t.Errorf("balance of %s read at block %s", address, params[1])
+			}
+			result = eth[address]
+		case "eth_call":
+			var call map[string]string
+			json.Unmarshal(params[0], &call)
+			if string(params[1]) != `"0xe4e1c0"` {
+				t.Errorf("call %s read at block %s", call["data"], params[1])
+			}
+			switch {
+			case call["data"] == decimalsSelector:
+				result = "0x0000000000000000000000000000000000000000000000000000000000000006"
+			case call["data"] == nameSelector:
+				// abi encoded "Tether USD"
+				result = "0x0000000000000000000000000000000000000000000000000000000000000020" +
+					"000000000000000000000000000000000000000000000000000000000000000a" +
+					"5465746865722055534400000000000000000000000000000000000000000000"
+			case call["data"] == totalSupplySelector:
+				// 39,823,315,849.9 at 6 decimals
+				result = "0x000000000000000000000000000000000000000000000000008d7b184302efe0"
+			case strings.HasPrefix(call["data"], balanceOfSelector):
+				result = tokens["0x"+call["data"][len(call["data"])-40:]]
+			}
+		default:
+			res.Error = &RPCError{-32601, "the method " + req.Method + " does not exist"}
+		}
+		if res.Error == nil {
+			res.Result, _ = json.Marshal(result)
+		}
+		return res
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body[0] != '[' {
+			var req rpcRequest
+			json.Unmarshal(body, &req)
+			json.NewEncoder(w).Encode(respond(req))
+			return
+		}
+		var reqs []rpcRequest
+		json.Unmarshal(body, &reqs)
+		var responses []rpcResponse
+		// answer in reverse to check that responses are matched by id
+		for i := len(reqs) - 1; i >= 0; i-- {
+			responses = append(responses, respond(reqs[i]))
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEthBalances(t *testing.T) {
+	server := fakeEthNode(t, map[string]string{
+		"0x00000000219ab540356cbb839cbe05303d7705fa": "0x1a784379d99db42000000",
+		"0xbe0eb53f46cd790cd13851d5eff43d12404d33e8": "0x0",
+	}, map[string]string{
+		"0x5754284f345afc66a98fbb0a0afe71e0f007b949": "0x00000000000000000000000000000000000000000000000000000a2fb4058000",
+		"0xbe0eb53f46cd790cd13851d5eff43d12404d33e8": "0x",
+	})
+	node := newEthNode(RPCConfig{URL: server.URL}, testFetcher(server))
+
+	eth := ethSource{node, "ethereum", "ETH"}
+	wallets, err := eth.Balances(context.Background(), []Wallet{
+		{Blockchain: "ethereum", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2 Deposit Contract", IsContract: true, OwnerType: "contract"},
+		{Blockchain: "ethereum", Address: "0xbe0eb53f46cd790cd13851d5eff43d12404d33e8", Name: "Binance 7", OwnerType: "exchange"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2 Deposit Contract", Balance: 2000000, IsContract: true, OwnerType: "contract", BlockHeight: 15000000},
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0xbe0eb53f46cd790cd13851d5eff43d12404d33e8", Name: "Binance 7", OwnerType: "exchange", BlockHeight: 15000000},
+	})
+
+	usdt := erc20Source{node, TokenContract{Blockchain: "ethereum", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Symbol: "USDT"}}
+	wallets, err = usdt.Balances(context.Background(), []Wallet{
+		{Blockchain: "ethereum", Address: "0x5754284f345afc66a98fbb0a0afe71e0f007b949", Name: "Tether: Treasury", OwnerType: "unknown"},
+		{Blockchain: "ethereum", Address: "0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8", Name: "Binance 7", OwnerType: "exchange"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0x5754284f345afc66a98fbb0a0afe71e0f007b949", Name: "Tether: Treasury", Balance: 11200000, OwnerType: "unknown", BlockHeight: 15000000},
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8", Name: "Binance 7", OwnerType: "exchange", BlockHeight: 15000000},
+	})
+}
+
+func TestEthBatches(t *testing.T) {
+	var requests int
+	server := fakeEthNode(t, map[string]string{}, nil)
+	counted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counted.Close()
+	node := newEthNode(RPCConfig{URL: counted.URL}, testFetcher(counted))
+	var whales []Wallet
+	for i := 0; i < ethBatchSize*2+1; i++ {
+		whales = append(whales, Wallet{Address: toQuantity(int64(i))})
+	}
+	wallets, err := ethSource{node, "ethereum", "ETH"}.Balances(context.Background(), whales)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets) != len(whales) {
+		t.Errorf("got %d wallets, want %d", len(wallets), len(whales))
+	}
+	// one for the block number then three batches
+	if requests != 4 {
+		t.Errorf("got %d requests, want 4", requests)
+	}
+}
+
+func TestContractCallerFor(t *testing.T) {
+	config := Config{
+		Sources:     map[string]string{"ethereum": modeRPC},
+		EthereumRPC: RPCConfig{URL: "http://localhost:8545"},
+		Tokens:      []TokenContract{{Symbol: "USDT", Blockchain: "ethereum", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7"}},
+	}
+	f := &Fetcher{}
+	sources, err := balanceSources(config, f, ethereum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// supplies are read at the block of the balances
+	if c := contractCallerFor(config, f, ethereum, sources); c != sources[0].(ethSource).node {
+		t.Errorf("got %v, want the node of the balance source", c)
+	}
+	if c := contractCallerFor(Config{}, f, ethereum, nil); c != nil {
+		t.Errorf("got %v, want nil without a node or api", c)
+	}
+}
+
+func TestBalanceSourcesPerChain(t *testing.T) {
+	bsc := Blockchain{Name: "bsc", Symbol: "BNB", RichList: "etherscan", API: &APIConfig{URL: "https://api.bscscan.com/api", Key: "bsc"}}
+	polygon := Blockchain{Name: "polygon", Symbol: "MATIC", RichList: "etherscan", RPC: &RPCConfig{URL: "http://localhost:8545"}}
+	config := Config{
+		Sources:      map[string]string{"bsc": modeAPI, "polygon": modeRPC, "ethereum": modeAPI, "arbitrum": modeAPI, "litecoin": modeRPC},
+		EtherscanAPI: APIConfig{Key: "etherscan"},
+		Tokens:       []TokenContract{{Symbol: "BUSD", Blockchain: "bsc", Address: "0xe9e7cea3dedca5984780bafc599bd69add087d56"}},
+	}
+	f := &Fetcher{}
+
+	sources, err := balanceSources(config, f, bsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, ok := sources[0].(etherscanAPISource)
+	if len(sources) != 2 || !ok || native.chain != "bsc" || native.symbol != "BNB" || native.api.config != *bsc.API {
+		t.Errorf("got %+v, want bnb and busd from the bscscan api", sources)
+	}
+	if c := contractCallerFor(config, f, bsc, nil); c == nil {
+		t.Error("got no contract caller for a chain with an api")
+	}
+
+	sources, err = balanceSources(config, f, polygon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src, ok := sources[0].(ethSource); len(sources) != 1 || !ok || src.symbol != "MATIC" {
+		t.Errorf("got %+v, want matic from the node", sources)
+	}
+
+	sources, err = balanceSources(config, f, ethereum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src, ok := sources[0].(etherscanAPISource); !ok || src.api.config.URL != etherscanAPIURL {
+		t.Errorf("got %+v, want ether from etherscan", sources)
+	}
+
+	for _, chain := range []Blockchain{
+		// only ethereum falls back to etherscan_api
+		{Name: "arbitrum", Symbol: "ETH", RichList: "etherscan"},
+		// an api without a url would read etherscan
+		{Name: "arbitrum", Symbol: "ETH", RichList: "etherscan", API: &APIConfig{Key: "arbiscan"}},
+		{Name: "litecoin", Symbol: "LTC", RichList: "bitinfocharts", RPC: &RPCConfig{URL: "http://localhost:9332"}},
+	} {
+		if _, err := balanceSources(config, f, chain); err == nil {
+			t.Errorf("%+v: got no error", chain)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+const (
+	// addresses per balancemulti request
+	balanceMultiSize = 20
+	etherscanAPIURL  = "https://api.etherscan.io/api"
+)
+
+type APIConfig struct {
+	// URL of the api. Defaults to etherscan
+	URL string `json:"url"`
+	Key string `json:"key"`
+}
+
+// etherscanAPI reads balances from an etherscan style json api
+type etherscanAPI struct {
+	config  APIConfig
+	fetcher *Fetcher
+}
+
+type apiResponse struct {
+	// Status is "1" on success
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
+func newEtherscanAPI(config APIConfig, f *Fetcher) etherscanAPI {
+	if config.URL == "" {
+		config.URL = etherscanAPIURL
+	}
+	return etherscanAPI{config, f}
+}
+
+func (a etherscanAPI) get(ctx context.Context, params url.Values) ([]byte, error) {
+	if a.config.Key != "" {
+		params.Set("apikey", a.config.Key)
+	}
+	return a.fetcher.Get(ctx, a.config.URL+"?"+params.Encode())
+}
+
+// account calls an action of the account module
+func (a etherscanAPI) account(ctx context.Context, params url.Values, result interface{}) error {
+	params.Set("module", "account")
+	params.Set("tag", "latest")
+	action := params.Get("action")
+	content, err := a.get(ctx, params)
+	if err != nil {
+		return fmt.Errorf("%s error: %w", action, err)
+	}
+	var res apiResponse
+	err = json.Unmarshal(content, &res)
+	if err != nil {
+		return fmt.Errorf("%s response error: %w", action, err)
+	}
+	if res.Status != "1" {
+		// the reason is in result. eg. Invalid API Key
+		var reason string
+		json.Unmarshal(res.Result, &reason)
+		return fmt.Errorf("%s error: %s %s", action, res.Message, reason)
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// ethCall calls a contract through the eth_call proxy
+func (a etherscanAPI) ethCall(ctx context.Context, to, data string) (string, error) {
+	content, err := a.get(ctx, url.Values{
+		"module": {"proxy"},
+		"action": {"eth_call"},
+		"to":     {to},
+		"data":   {data},
+		"tag":    {"latest"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("eth_call error: %w", err)
+	}
+	var res rpcResponse
+	err = json.Unmarshal(content, &res)
+	if err != nil {
+		return "", fmt.Errorf("eth_call response error: %w", err)
+	}
+	if res.Error != nil {
+		return "", fmt.Errorf("eth_call error: %w", res.Error)
+	}
+	var hex string
+	err = json.Unmarshal(res.Result, &hex)
+	return hex, err
+}
+
+// etherscanAPISource reads the native balance of known whales with balancemulti
+type etherscanAPISource struct {
+	api    etherscanAPI
+	chain  string
+	symbol string
+}
+
+func (etherscanAPISource) Source() string   { return "etherscan_api" }
+func (s etherscanAPISource) Chain() string  { return s.chain }
+func (s etherscanAPISource) Symbol() string { return s.symbol }
+
+func (s etherscanAPISource) Balances(ctx context.Context, whales []Wallet) ([]Wallet, error) {
+	amounts := make([]*big.Int, len(whales))
+	for start := 0; start < len(whales); start += balanceMultiSize {
+		end := start + balanceMultiSize
+		if end > len(whales) {
+			end = len(whales)
+		}
+		var addresses []string
+		for _, whale := range whales[start:end] {
+			addresses = append(addresses, whale.Address)
+		}
+		var result []struct {
+			Account string `json:"account"`
+			Balance string `json:"balance"`
+		}
+		err := s.api.account(ctx, url.Values{
+			"action":  {"balancemulti"},
+			"address": {strings.Join(addresses, ",")},
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		balances := map[string]string{}
+		for _, r := range result {
+			balances[strings.ToLower(r.Account)] = r.Balance
+		}
+		for i, whale := range whales[start:end] {
+			amount, err := parseAmount(balances[strings.ToLower(whale.Address)])
+			if err != nil {
+				return nil, fmt.Errorf("%s balance error: %w", whale.Address, err)
+			}
+			amounts[start+i] = amount
+		}
+	}
+	return withBalances(whales, amounts, 18, s.symbol, 0), nil
+}
+
+// etherscanTokenAPISource reads the token balance of known holders with tokenbalance
+type etherscanTokenAPISource struct {
+	api   etherscanAPI
+	token TokenContract
+}
+
+func (etherscanTokenAPISource) Source() string   { return "etherscan_api" }
+func (s etherscanTokenAPISource) Chain() string  { return s.token.Blockchain }
+func (s etherscanTokenAPISource) Symbol() string { return s.token.Symbol }
+
+func (s etherscanTokenAPISource) Balances(ctx context.Context, whales []Wallet) ([]Wallet, error) {
+	if len(whales) == 0 {
+		return nil, nil
+	}
+	decimals, err := readDecimals(ctx, s.api, s.token)
+	if err != nil {
+		return nil, err
+	}
+	var amounts []*big.Int
+	for _, whale := range whales {
+		var balance string
+		err := s.api.account(ctx, url.Values{
+			"action":          {"tokenbalance"},
+			"contractaddress": {s.token.Address},
+			"address":         {whale.Address},
+		}, &balance)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := parseAmount(balance)
+		if err != nil {
+			return nil, fmt.Errorf("%s balance error: %w", whale.Address, err)
+		}
+		amounts = append(amounts, amount)
+	}
+	return withBalances(whales, amounts, decimals, s.token.Symbol, 0), nil
+}
+
+// parseAmount reads a decimal amount in the smallest unit
+func parseAmount(text string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", text)
+	}
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeEtherscanAPI answers balancemulti, tokenbalance and the decimals eth_call of a 6 decimal token
+func fakeEtherscanAPI(t *testing.T, balances map[string]string) (*httptest.Server, *int) {
+	t.Helper()
+	var multi int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if q.Get("apikey") != "key" {
+			w.Write([]byte(`{"status":"0","message":"NOTOK","result":"Invalid API Key"}`))
+			return
+		}
+		switch q.Get("module") + "." + q.Get("action") {
+		case "account.balancemulti":
+			multi++
+			addresses := strings.Split(q.Get("address"), ",")
+			if len(addresses) > balanceMultiSize {
+				t.Errorf("balancemulti of %d addresses", len(addresses))
+			}
+			var result []string
+			for _, a := range addresses {
+				balance, ok := balances[strings.ToLower(a)]
+				if !ok {
+					balance = "0"
+				}
+				result = append(result, fmt.Sprintf(`{"account":"%s","balance":"%s"}`, a, balance))
+			}
+			fmt.Fprintf(w, `{"status":"1","message":"OK","result":[%s]}`, strings.Join(result, ","))
+		case "account.tokenbalance":
+			if q.Get("contractaddress") != "0xdac17f958d2ee523a2206206994597c13d831ec7" {
+				t.Errorf("unexpected contract %s", q.Get("contractaddress"))
+			}
+			fmt.Fprintf(w, `{"status":"1","message":"OK","result":"%s"}`, balances[strings.ToLower(q.Get("address"))])
+		case "proxy.eth_call":
+			if q.Get("data") != decimalsSelector {
+				t.Errorf("unexpected call %s", q.Get("data"))
+			}
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x0000000000000000000000000000000000000000000000000000000000000006"}`))
+		default:
+			w.Write([]byte(`{"status":"0","message":"NOTOK","result":"Error! Missing Or invalid Action name"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &multi
+}
+
+func TestEtherscanAPIBalances(t *testing.T) {
+	server, multi := fakeEtherscanAPI(t, map[string]string{
+		"0x00000000219ab540356cbb839cbe05303d7705fa": "2000000000000000000000000",
+		"0x5754284f345afc66a98fbb0a0afe71e0f007b949": "11200000000000",
+	})
+	api := newEtherscanAPI(APIConfig{URL: server.URL + "/api", Key: "key"}, testFetcher(server))
+
+	whales := []Wallet{
+		{Blockchain: "ethereum", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2 Deposit Contract", IsContract: true, OwnerType: "contract"},
+	}
+	for i := 0; i < balanceMultiSize; i++ {
+		whales = append(whales, Wallet{Blockchain: "ethereum", Address: fmt.Sprintf("0x%040x", i), OwnerType: "unknown"})
+	}
+	wallets, err := etherscanAPISource{api, "ethereum", "ETH"}.Balances(context.Background(), whales)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *multi != 2 {
+		t.Errorf("got %d balancemulti requests, want 2", *multi)
+	}
+	if len(wallets) != len(whales) {
+		t.Fatalf("got %d wallets, want %d", len(wallets), len(whales))
+	}
+	assertWallets(t, wallets[:2], []Wallet{
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2 Deposit Contract", Balance: 2000000, IsContract: true, OwnerType: "contract"},
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0x0000000000000000000000000000000000000000", OwnerType: "unknown"},
+	})
+
+	usdt := etherscanTokenAPISource{api, TokenContract{Blockchain: "ethereum", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Symbol: "USDT"}}
+	wallets, err = usdt.Balances(context.Background(), []Wallet{
+		{Blockchain: "ethereum", Address: "0x5754284F345afc66a98fbB0a0Afe71e0F007B949", Name: "Tether: Treasury", OwnerType: "unknown"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0x5754284F345afc66a98fbB0a0Afe71e0F007B949", Name: "Tether: Treasury", Balance: 11200000, OwnerType: "unknown"},
+	})
+}
+
+func TestEtherscanAPIErrors(t *testing.T) {
+	server, _ := fakeEtherscanAPI(t, nil)
+	whales := []Wallet{{Address: "0x00000000219ab540356cbb839cbe05303d7705fa"}}
+
+	api := newEtherscanAPI(APIConfig{URL: server.URL + "/api", Key: "wrong"}, testFetcher(server))
+	_, err := etherscanAPISource{api, "ethereum", "ETH"}.Balances(context.Background(), whales)
+	if err == nil || !strings.Contains(err.Error(), "Invalid API Key") {
+		t.Errorf("got %v, want invalid api key", err)
+	}
+
+	api = newEtherscanAPI(APIConfig{URL: server.URL + "/missing", Key: "key"}, testFetcher(server))
+	_, err = etherscanAPISource{api, "ethereum", "ETH"}.Balances(context.Background(), whales)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want not found", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type HTTPConfig struct {
+	UserAgent string `json:"user_agent"`
+	// TimeoutSeconds limits each request including reading the body
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// Retries is the number of times a failed request is repeated
+	Retries int `json:"retries"`
+	// BackoffMillis is the wait before the first retry. It doubles every retry
+	BackoffMillis int `json:"backoff_ms"`
+	// MaxBackoffSeconds caps the wait between retries
+	MaxBackoffSeconds int `json:"max_backoff_seconds"`
+	// RateLimits keeps requests to each host under its limit to avoid being blocked
+	RateLimits map[string]RateLimit `json:"rate_limits"`
+	// Workers is the number of pages of a scrape fetched at once
+	Workers int `json:"workers"`
+}
+
+// Fetcher makes http requests with timeouts and retries that respect cancellation
+type Fetcher struct {
+	Client     *http.Client
+	UserAgent  string
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Limiter    *HostLimiter
+	Workers    int
+	// JSONErrors returns server errors with a json body right away. JSON-RPC servers explain failed calls that way
+	JSONErrors bool
+	// NoRetryTimeouts gives up on requests that ran out of time instead of repeating calls too slow to finish
+	NoRetryTimeouts bool
+}
+
+// StatusError is returned when a server responds with anything but 200
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration
+	// Body is the start of the response which may explain the error
+	Body []byte
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("status code error: %d %s", e.Code, e.Status)
+}
+
+// Temporary is true for rate limits and server errors
+func (e StatusError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+func newFetcher(config HTTPConfig) *Fetcher {
+	f := &Fetcher{
+		Client:     &http.Client{},
+		UserAgent:  config.UserAgent,
+		Timeout:    30 * time.Second,
+		Retries:    10,
+		Backoff:    300 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		Limiter:    newHostLimiter(config.RateLimits),
+		Workers:    4,
+	}
+	if config.TimeoutSeconds > 0 {
+		f.Timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	if config.Retries > 0 {
+		f.Retries = config.Retries
+	}
+	if config.BackoffMillis > 0 {
+		f.Backoff = time.Duration(config.BackoffMillis) * time.Millisecond
+	}
+	if config.MaxBackoffSeconds > 0 {
+		f.MaxBackoff = time.Duration(config.MaxBackoffSeconds) * time.Second
+	}
+	if config.Workers > 0 {
+		f.Workers = config.Workers
+	}
+	return f
+}
+
+func (f *Fetcher) Get(ctx context.Context, url string) ([]byte, error) {
+	return f.Do(ctx, http.MethodGet, url, nil, nil)
+}
+
+// Do sends a request until it succeeds, fails permanently, runs out of retries or ctx is done
+func (f *Fetcher) Do(ctx context.Context, method, rawURL string, header http.Header, body []byte) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		err := f.Limiter.Wait(ctx, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		content, err := f.do(ctx, method, rawURL, header, body)
+		if err == nil {
+			return content, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if f.NoRetryTimeouts && errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s timed out after %s: %w", rawURL, f.Timeout, err)
+		}
+		wait := f.backoff(attempt)
+		var serr StatusError
+		if errors.As(err, &serr) {
+			if !serr.Temporary() || (f.JSONErrors && jsonBody(serr.Body)) {
+				return nil, err
+			}
+			if serr.RetryAfter > wait {
+				wait = serr.RetryAfter
+			}
+		}
+		if attempt >= f.Retries {
+			return nil, fmt.Errorf("%s failed after %d attempts: %w", rawURL, attempt+1, err)
+		}
+		fmt.Printf("%s: %s. retrying in %s\n", rawURL, err, wait)
+		err = sleep(ctx, wait)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *Fetcher) do(ctx context.Context, method, url string, header http.Header, body []byte) ([]byte, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, StatusError{res.StatusCode, res.Status, retryAfter(res.Header.Get("Retry-After")), body}
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// jsonBody is true for bodies that look like a json object or array
+func jsonBody(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+// backoff grows exponentially with the attempt. Half of it is random so retries don't align
+func (f *Fetcher) backoff(attempt int) time.Duration {
+	wait := f.Backoff
+	for i := 0; i < attempt && (f.MaxBackoff <= 0 || wait < f.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if f.MaxBackoff > 0 && wait > f.MaxBackoff {
+		wait = f.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retryAfter reads the Retry-After header which is either seconds or a date
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetcherRetryAfter(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.UserAgent() != "whales" {
+			t.Errorf("got user agent %q", r.UserAgent())
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	f := &Fetcher{Client: server.Client(), UserAgent: "whales", Retries: 1, Backoff: time.Millisecond}
+	start := time.Now()
+	body, err := f.Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&attempts); string(body) != "ok" || n != 2 {
+		t.Errorf("got %q after %d attempts", body, n)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, expected Retry-After of 1s", waited)
+	}
+}
+
+func TestFetcherPermanentError(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	f := &Fetcher{Client: server.Client(), Retries: 5}
+	_, err := f.Get(context.Background(), server.URL)
+	var serr StatusError
+	if !errors.As(err, &serr) || serr.Code != http.StatusNotFound {
+		t.Fatalf("got %v, want 404", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
+
+func TestFetcherTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	f := &Fetcher{Client: server.Client(), Timeout: 50 * time.Millisecond, Retries: 2, Backoff: time.Millisecond}
+	_, err := f.Get(context.Background(), server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	f = &Fetcher{Client: server.Client(), Retries: 2}
+	_, err = f.Get(ctx, server.URL)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want canceled", err)
+	}
+}
+
+func TestFetcherJSONErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"result":null,"error":{"code":-8,"message":"Scan already in progress"},"id":1}`))
+	}))
+	defer server.Close()
+
+	f := &Fetcher{Client: server.Client(), Retries: 2, Backoff: time.Millisecond}
+	_, err := f.Get(context.Background(), server.URL)
+	if n := atomic.LoadInt32(&attempts); err == nil || n != 3 {
+		t.Errorf("got %v after %d attempts, want 3", err, n)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	f.JSONErrors = true
+	_, err = f.Get(context.Background(), server.URL)
+	var serr StatusError
+	if !errors.As(err, &serr) || !strings.Contains(string(serr.Body), "Scan already in progress") {
+		t.Errorf("got %v, want the error body", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
+
+func TestFetcherNoRetryTimeouts(t *testing.T) {
+	var attempts int32
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	f := &Fetcher{Client: server.Client(), Timeout: 50 * time.Millisecond, Retries: 2, Backoff: time.Millisecond, NoRetryTimeouts: true}
+	_, err := f.Get(context.Background(), server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	f := &Fetcher{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		wait := f.backoff(attempt)
+		if wait < max/2 || wait > max {
+			t.Errorf("attempt %d waited %s, want between %s and %s", attempt, wait, max/2, max)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PageSchema is what a page of a rich list is expected to look like.
+// Pages that don't match are a sign that the site changed its layout
+type PageSchema struct {
+	// Headers that should be present in the table. Matched case insensitively
+	Headers []string
+	// MinRows is the least number of wallets a complete page should have
+	MinRows int
+	// MinParseRate is the least fraction of rows that should have a readable balance
+	MinParseRate float64
+}
+
+// PageHealth is how well a page matched its schema
+type PageHealth struct {
+	Page           int      `json:"page"`
+	URL            string   `json:"url"`
+	Rows           int      `json:"rows"`
+	Parsed         int      `json:"parsed"`
+	Wallets        int      `json:"wallets"`
+	MissingHeaders []string `json:"missing_headers,omitempty"`
+	Problem        string   `json:"problem,omitempty"`
+	// Hash of the archived page
+	Hash string `json:"hash,omitempty"`
+}
+
+// HealthReport summarizes the pages of a single scrape
+type HealthReport struct {
+	Source string
+	Chain  string
+	Symbol string
+	Pages  []PageHealth
+}
+
+func (r HealthReport) Healthy() bool {
+	return len(r.Problems()) == 0
+}
+
+func (r HealthReport) Problems() []string {
+	var problems []string
+	for _, p := range r.Pages {
+		if p.Problem != "" {
+			problems = append(problems, fmt.Sprintf("page %d: %s", p.Page, p.Problem))
+		}
+	}
+	return problems
+}
+
+func (r HealthReport) totals() (rows, parsed, wallets int) {
+	for _, p := range r.Pages {
+		rows += p.Rows
+		parsed += p.Parsed
+		wallets += p.Wallets
+	}
+	return rows, parsed, wallets
+}
+
+func headers(doc *goquery.Document) []string {
+	var hs []string
+	doc.Find("th").Each(func(i int, s *goquery.Selection) {
+		hs = append(hs, strings.ToLower(strings.Join(strings.Fields(s.Text()), " ")))
+	})
+	return hs
+}
+
+// check compares a page against the schema and records the first problem found
+func (h *PageHealth) check(schema PageSchema, found []string) {
+	for _, expected := range schema.Headers {
+		expected = strings.ToLower(expected)
+		var ok bool
+		for _, f := range found {
+			if strings.Contains(f, expected) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			h.MissingHeaders = append(h.MissingHeaders, expected)
+		}
+	}
+	switch {
+	case len(h.MissingHeaders) > 0:
+		h.Problem = fmt.Sprintf("missing headers %s", strings.Join(h.MissingHeaders, ", "))
+	case h.Wallets < schema.MinRows:
+		h.Problem = fmt.Sprintf("found %d wallets, expected at least %d", h.Wallets, schema.MinRows)
+	case h.Rows > 0 && float64(h.Parsed)/float64(h.Rows) < schema.MinParseRate:
+		h.Problem = fmt.Sprintf("parsed %d of %d balances, expected at least %.0f%%", h.Parsed, h.Rows, schema.MinParseRate*100)
+	default:
+		h.Problem = ""
+	}
+}
+
+func logHealth(ctx context.Context, conn *pgxpool.Pool, runID int, report HealthReport) error {
+	query := `
+		INSERT INTO scrape_health
+		(source, blockchain, symbol, page_count, row_count, parsed_count, wallet_count, healthy, detail, run_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+	`
+	detail, err := json.Marshal(report.Pages)
+	if err != nil {
+		return err
+	}
+	rows, parsed, wallets := report.totals()
+	_, err = conn.Exec(ctx, query, report.Source, report.Chain, report.Symbol, len(report.Pages), rows, parsed, wallets, report.Healthy(), detail, runID)
+	if err != nil {
+		return fmt.Errorf("log health error: %w", err)
+	}
+	return nil
+}
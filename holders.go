@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// HolderConfig sets how holders are told apart. Zero values use the defaults
+type HolderConfig struct {
+	// WindowDays of batches in the series. Defaults to 31
+	WindowDays int `json:"window_days"`
+	// LookbackDays before a batch to find the highest balance of a holder in. Defaults to 30
+	LookbackDays int `json:"lookback_days"`
+	// Tolerance is how far below its highest balance a holder can be and still be a diamond hand.
+	// In coins. Defaults to 1
+	Tolerance *float64 `json:"tolerance,omitempty"`
+}
+
+func (c HolderConfig) windowDays() int {
+	if c.WindowDays <= 0 {
+		return 31
+	}
+	return c.WindowDays
+}
+
+func (c HolderConfig) lookbackDays() int {
+	if c.LookbackDays <= 0 {
+		return 30
+	}
+	return c.LookbackDays
+}
+
+func (c HolderConfig) tolerance() float64 {
+	if c.Tolerance == nil {
+		return 1
+	}
+	return *c.Tolerance
+}
+
+// balanceRow is the balance of a whale in a batch
+type balanceRow struct {
+	// Date is the batch time in unix seconds
+	Date       int64
+	WhaleID    int
+	OwnerType  string
+	IsContract bool
+	Value      float64
+}
+
+// holder is true for whales whose balance says something about holding.
+// Exchanges, staking, wrapping and contracts hold for others
+func holder(ownerType string, isContract bool) bool {
+	if isContract {
+		return false
+	}
+	switch ownerType {
+	case "exchange", "stake", "wrap", "burn":
+		return false
+	}
+	return true
+}
+
+type peak struct {
+	date  int64
+	value float64
+}
+
+// internalMove is what a whale moved into exchanges in a batch. Negative for what exchanges moved out to it
+type internalMove struct {
+	// Date is the batch time in unix seconds
+	Date    int64
+	WhaleID int
+	Amount  float64
+}
+
+// buckets a balance can be summed into. They match the json keys of Series
+const (
+	bucketExchange     = "exchange"
+	bucketWrap         = "wrap"
+	bucketStake        = "stake"
+	bucketDiamondHands = "diamond_hands"
+	bucketPaperHands   = "paper_hands"
+	// bucketOther is for contracts and burns which are not summed
+	bucketOther = "other"
+)
+
+// seriesBuilder sums balances into a series per batch.
+// A holder is a paper hand in a batch if its balance is more than the tolerance below
+// its highest balance in the lookback before it and a diamond hand otherwise
+type seriesBuilder struct {
+	// since is the first batch in the series. Earlier rows only count toward peaks
+	since     int64
+	lookback  int64
+	tolerance float64
+	byDate    map[int64]*Series
+	// moves by whale that were not reached yet
+	moves   map[int][]internalMove
+	whaleID int
+	// bucket of the current whale in its latest batch
+	bucket string
+	// peaks of the current whale in decreasing order of value for a sliding maximum
+	peaks []peak
+	// moves of the current whale ordered by date
+	pending []internalMove
+}
+
+func newSeriesBuilder(config HolderConfig, since int64) *seriesBuilder {
+	return &seriesBuilder{
+		since:     since,
+		lookback:  int64(config.lookbackDays()) * 24 * 60 * 60,
+		tolerance: config.tolerance(),
+		byDate:    map[int64]*Series{},
+		moves:     map[int][]internalMove{},
+		whaleID:   -1,
+	}
+}
+
+// move records a move between a whale and exchanges. Moves must be recorded before the rows of the whale
+func (s *seriesBuilder) move(m internalMove) {
+	s.moves[m.WhaleID] = append(s.moves[m.WhaleID], m)
+}
+
+// add counts a balance. Rows must be ordered by whale then date
+func (s *seriesBuilder) add(row balanceRow) {
+	if row.WhaleID != s.whaleID {
+		s.settle(math.MaxInt64)
+		s.whaleID = row.WhaleID
+		s.bucket = bucketOther
+		s.peaks = s.peaks[:0]
+		s.pending = s.moves[row.WhaleID]
+		delete(s.moves, row.WhaleID)
+		sort.Slice(s.pending, func(i, j int) bool {
+			return s.pending[i].Date < s.pending[j].Date
+		})
+	}
+	s.settle(row.Date)
+	moved := 0.0
+	for len(s.pending) > 0 && s.pending[0].Date == row.Date {
+		moved += s.pending[0].Amount
+		s.pending = s.pending[1:]
+	}
+	// earlier balances are shifted by moves to and from exchanges
+	// so moving coins between tracked whales never looks like selling or buying
+	for i := range s.peaks {
+		s.peaks[i].value -= moved
+	}
+	for len(s.peaks) > 0 && s.peaks[0].date < row.Date-s.lookback {
+		s.peaks = s.peaks[1:]
+	}
+	highest := row.Value
+	if len(s.peaks) > 0 && s.peaks[0].value > highest {
+		highest = s.peaks[0].value
+	}
+	for len(s.peaks) > 0 && s.peaks[len(s.peaks)-1].value <= row.Value {
+		s.peaks = s.peaks[:len(s.peaks)-1]
+	}
+	s.peaks = append(s.peaks, peak{row.Date, row.Value})
+
+	switch {
+	case row.OwnerType == "exchange":
+		s.bucket = bucketExchange
+	case row.OwnerType == "wrap":
+		s.bucket = bucketWrap
+	case row.OwnerType == "stake":
+		s.bucket = bucketStake
+	case !holder(row.OwnerType, row.IsContract):
+		s.bucket = bucketOther
+	case highest > row.Value+s.tolerance:
+		s.bucket = bucketPaperHands
+	default:
+		s.bucket = bucketDiamondHands
+	}
+	if row.Date < s.since {
+		return
+	}
+	series := s.at(row.Date)
+	switch s.bucket {
+	case bucketExchange:
+		series.Exchange += row.Value
+	case bucketWrap:
+		series.Wrap += row.Value
+	case bucketStake:
+		series.Stake += row.Value
+	case bucketPaperHands:
+		series.PaperHands += row.Value
+		series.PaperHandsCount++
+	case bucketDiamondHands:
+		series.DiamondHands += row.Value
+		series.DiamondHandsCount++
+	}
+	if moved != 0 {
+		series.addInternal(s.bucket, moved)
+	}
+}
+
+// settle counts the moves of the current whale before date in the bucket it was last in.
+// A whale can move coins in a batch it is missing from, like when it empties its wallet
+func (s *seriesBuilder) settle(date int64) {
+	for len(s.pending) > 0 && s.pending[0].Date < date {
+		m := s.pending[0]
+		s.pending = s.pending[1:]
+		if m.Date >= s.since {
+			s.at(m.Date).addInternal(s.bucket, m.Amount)
+		}
+	}
+}
+
+func (s *seriesBuilder) at(date int64) *Series {
+	series, ok := s.byDate[date]
+	if !ok {
+		series = &Series{Date: date}
+		s.byDate[date] = series
+	}
+	return series
+}
+
+func (s *Series) addInternal(bucket string, amount float64) {
+	if s.Internal == nil {
+		s.Internal = map[string]float64{}
+	}
+	s.Internal[bucket] += amount
+}
+
+// series are ordered by date
+func (s *seriesBuilder) series() []Series {
+	s.settle(math.MaxInt64)
+	// whales without balances in the window and lookback
+	for _, moves := range s.moves {
+		for _, m := range moves {
+			if m.Date >= s.since {
+				s.at(m.Date).addInternal(bucketOther, m.Amount)
+			}
+		}
+	}
+	s.moves = map[int][]internalMove{}
+	data := make([]Series, 0, len(s.byDate))
+	for _, series := range s.byDate {
+		data = append(data, *series)
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Date < data[j].Date
+	})
+	return data
+}
+
+// generate_series reads the balances of an asset in the window and the lookback before it
+// and classifies them the same way for every chain. Moves between whales and exchanges are kept by bucket
+func generate_series(ctx context.Context, conn *pgx.Conn, blockchain, symbol, membership string, config HolderConfig, moves []internalMove) ([]Series, error) {
+	query := fmt.Sprintf(`
+	select
+		extract(epoch from r.batch_at)::bigint as epoch,
+		b.whale_id,
+		w.owner_type,
+		w.is_contract,
+		b.value
+	from %s b
+	join scrape_run r using(run_id)
+	join whale w using(whale_id)
+	where r.batch_at > now() - make_interval(days => $3::int + $4::int)
+	and r.status = 'success'
+	and b.symbol = $1
+	and w.blockchain = $2
+	order by b.whale_id, r.batch_at
+	;
+	`, balanceRows(membership))
+	rows, err := conn.Query(ctx, query, symbol, blockchain, config.windowDays(), config.lookbackDays())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+	builder := newSeriesBuilder(config, time.Now().AddDate(0, 0, -config.windowDays()).Unix())
+	for _, m := range moves {
+		builder.move(m)
+	}
+	for rows.Next() {
+		var row balanceRow
+		err := rows.Scan(&row.Date, &row.WhaleID, &row.OwnerType, &row.IsContract, &row.Value)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		builder.add(row)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("row error: %w", rows.Err())
+	}
+	return builder.series(), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const hour = 60 * 60
+
+// history is the balances of one whale at consecutive hours
+func history(whaleID int, ownerType string, isContract bool, start int64, values ...float64) []balanceRow {
+	var rows []balanceRow
+	for i, v := range values {
+		rows = append(rows, balanceRow{Date: start + int64(i)*hour, WhaleID: whaleID, OwnerType: ownerType, IsContract: isContract, Value: v})
+	}
+	return rows
+}
+
+func buildSeries(config HolderConfig, since int64, histories ...[]balanceRow) []Series {
+	builder := newSeriesBuilder(config, since)
+	for _, h := range histories {
+		for _, row := range h {
+			builder.add(row)
+		}
+	}
+	return builder.series()
+}
+
+func TestHolderClassification(t *testing.T) {
+	got := buildSeries(HolderConfig{}, 0,
+		// accumulating stays a diamond hand
+		history(1, "unknown", false, 0, 100, 110, 120),
+		// selling below its peak becomes a paper hand
+		history(2, "unknown", false, 0, 50, 60, 40),
+		// within the tolerance of its peak
+		history(3, "unknown", false, 0, 10, 10.5, 10),
+		history(4, "exchange", false, 0, 1000, 900, 950),
+		// contracts and staking are not holders even when they sell
+		history(5, "unknown", true, 0, 30, 20, 10),
+		history(6, "stake", false, 0, 5, 5, 5),
+		history(7, "burn", false, 0, 1, 1, 1),
+	)
+	want := []Series{
+		{Date: 0, Exchange: 1000, Stake: 5, DiamondHands: 160, DiamondHandsCount: 3},
+		{Date: hour, Exchange: 900, Stake: 5, DiamondHands: 180.5, DiamondHandsCount: 3},
+		{Date: 2 * hour, Exchange: 950, Stake: 5, DiamondHands: 130, DiamondHandsCount: 2, PaperHands: 40, PaperHandsCount: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestHolderLookback(t *testing.T) {
+	zero := 0.0
+	config := HolderConfig{LookbackDays: 1, Tolerance: &zero}
+	// peak at hour 0 then steady at 90 for 2 days
+	values := []float64{100}
+	for i := 0; i < 48; i++ {
+		values = append(values, 90)
+	}
+	got := buildSeries(config, 24*hour, history(1, "unknown", false, 0, values...))
+	if len(got) != 25 {
+		t.Fatalf("got %d batches, want 25 after since", len(got))
+	}
+	// the peak is exactly a day before the first batch in the series
+	if got[0].PaperHandsCount != 1 {
+		t.Errorf("got %+v at the end of the lookback, want a paper hand", got[0])
+	}
+	if got[1].DiamondHandsCount != 1 {
+		t.Errorf("got %+v after the peak left the lookback, want a diamond hand", got[1])
+	}
+}
+
+func TestHolderPeaksPerWhale(t *testing.T) {
+	// a later whale must not inherit the peak of an earlier one
+	got := buildSeries(HolderConfig{}, 0,
+		history(1, "unknown", false, 0, 1000),
+		history(2, "unknown", false, 0, 10),
+	)
+	want := []Series{{Date: 0, DiamondHands: 1010, DiamondHandsCount: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHolderInternalMoves(t *testing.T) {
+	builder := newSeriesBuilder(HolderConfig{}, 0)
+	for _, m := range []internalMove{
+		// sending half to an exchange is not selling
+		{Date: hour, WhaleID: 1, Amount: 500},
+		// already a paper hand
+		{Date: 2 * hour, WhaleID: 2, Amount: 30},
+		{Date: hour, WhaleID: 3, Amount: 10},
+		// received from an exchange
+		{Date: hour, WhaleID: 4, Amount: -40},
+		// emptied its wallet so it is missing from the batch
+		{Date: hour, WhaleID: 5, Amount: 70},
+	} {
+		builder.move(m)
+	}
+	for _, h := range [][]balanceRow{
+		history(1, "unknown", false, 0, 1000, 500, 500),
+		history(2, "unknown", false, 0, 100, 50, 20),
+		history(3, "unknown", true, 0, 30, 20, 20),
+		history(4, "unknown", false, 0, 60, 100, 100),
+		history(5, "unknown", false, 0, 70),
+	} {
+		for _, row := range h {
+			builder.add(row)
+		}
+	}
+	want := []Series{
+		{Date: 0, DiamondHands: 1230, DiamondHandsCount: 4},
+		{Date: hour, DiamondHands: 600, DiamondHandsCount: 2, PaperHands: 50, PaperHandsCount: 1,
+			Internal: map[string]float64{"diamond_hands": 530, "other": 10}},
+		{Date: 2 * hour, DiamondHands: 600, DiamondHandsCount: 2, PaperHands: 20, PaperHandsCount: 1,
+			Internal: map[string]float64{"paper_hands": 30}},
+	}
+	if got := builder.series(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+// insertHistory saves the balances of one whale at consecutive hours from start, one run per hour
+func insertHistory(t *testing.T, pool *pgxpool.Pool, runs map[time.Time]Run, address, ownerType string, isContract bool, start time.Time, values ...float64) {
+	t.Helper()
+	whaleID := insertWhale(t, pool, "ethereum", address, ownerType, isContract)
+	for i, v := range values {
+		at := start.Add(time.Duration(i) * time.Hour)
+		run, ok := runs[at]
+		if !ok {
+			run = insertRun(t, pool, "etherscan", "ethereum", "ETH", at)
+			runs[at] = run
+		}
+		insertBalance(t, pool, run, whaleID, v)
+	}
+}
+
+// TestGenerateSeries reads balances for the builder in the order it needs
+func TestGenerateSeries(t *testing.T) {
+	pool := testPool(t)
+	start := time.Now().Truncate(time.Second).Add(-150 * time.Minute)
+	runs := map[time.Time]Run{}
+	histories := []struct {
+		ownerType  string
+		isContract bool
+		values     []float64
+	}{
+		// accumulating stays a diamond hand
+		{"unknown", false, []float64{100, 110, 120}},
+		// selling below its peak becomes a paper hand
+		{"unknown", false, []float64{50, 60, 40}},
+		// within the tolerance of its peak
+		{"unknown", false, []float64{10, 10.5, 10}},
+		{"exchange", false, []float64{1000, 900, 950}},
+		// contracts and staking are not holders even when they sell
+		{"unknown", true, []float64{30, 20, 10}},
+		{"stake", false, []float64{5, 5, 5}},
+		{"burn", false, []float64{1, 1, 1}},
+	}
+	for i, h := range histories {
+		insertHistory(t, pool, runs, fmt.Sprintf("0x%d", i), h.ownerType, h.isContract, start, h.values...)
+	}
+	got, err := generate_series(context.Background(), testConn(t, pool), "ethereum", "ETH", membershipNone, HolderConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Series{
+		{Date: start.Unix(), Exchange: 1000, Stake: 5, DiamondHands: 160, DiamondHandsCount: 3},
+		{Date: start.Unix() + hour, Exchange: 900, Stake: 5, DiamondHands: 180.5, DiamondHandsCount: 3},
+		{Date: start.Unix() + 2*hour, Exchange: 950, Stake: 5, DiamondHands: 130, DiamondHandsCount: 2, PaperHands: 40, PaperHandsCount: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AddressTag is a row of a public address tag dataset
+type AddressTag struct {
+	Address string `json:"address"`
+	// Chain is the name or symbol of a configured chain. eg. ethereum or ETH
+	Chain string `json:"chain"`
+	// Entity owns the address. eg. Binance
+	Entity   string `json:"entity"`
+	Category string `json:"category"`
+	// Confidence from 0 to 1. Defaults to the confidence of the import
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// owner types of dataset categories. Other categories only name the owner
+var categoryOwnerTypes = map[string]string{
+	"exchange": "exchange",
+	"cex":      "exchange",
+	"staking":  "stake",
+	"stake":    "stake",
+	"wrapped":  "wrap",
+	"wrap":     "wrap",
+	"burn":     "burn",
+	"contract": "contract",
+	"unknown":  "unknown",
+}
+
+// importLabelsCommand reads tag files and saves them as labels.
+// usage: import-labels [-source name] [-confidence 0.8] file.csv file.json
+func importLabelsCommand(ctx context.Context, pool *pgxpool.Pool, chains []Blockchain, namer entityNamer, args []string) error {
+	flags := flag.NewFlagSet("import-labels", flag.ContinueOnError)
+	source := flags.String("source", "", "where the tags came from. defaults to the file name")
+	confidence := flags.Float64("confidence", 0.5, "confidence of tags that don't have one, from 0 to 1")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no files to import")
+	}
+	for _, path := range flags.Args() {
+		tags, err := readTags(path)
+		if err != nil {
+			return fmt.Errorf("%s error: %w", path, err)
+		}
+		src := *source
+		if src == "" {
+			src = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		labels, skipped := tagLabels(tags, chains, src, *confidence)
+		updated, err := importLabels(ctx, pool, namer, labels, src)
+		if err != nil {
+			return fmt.Errorf("%s error: %w", path, err)
+		}
+		fmt.Printf("imported %d labels from %s (%d skipped). %d whales updated\n", len(labels), path, skipped, updated)
+	}
+	return nil
+}
+
+// readTags reads a csv with a header row or a json array depending on the extension
+func readTags(path string) ([]AddressTag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return parseTagsCSV(f)
+	case ".json":
+		var tags []AddressTag
+		err = json.NewDecoder(f).Decode(&tags)
+		return tags, err
+	}
+	return nil, fmt.Errorf("unsupported file type %q", filepath.Ext(path))
+}
+
+// parseTagsCSV reads address, chain, entity, category and confidence columns in any order
+func parseTagsCSV(r io.Reader) ([]AddressTag, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header error: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"address", "chain"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+	value := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var tags []AddressTag
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return tags, nil
+		}
+		if err != nil {
+			return tags, err
+		}
+		tag := AddressTag{
+			Address:  value(record, "address"),
+			Chain:    value(record, "chain"),
+			Entity:   value(record, "entity"),
+			Category: value(record, "category"),
+		}
+		if c := value(record, "confidence"); c != "" {
+			tag.Confidence, err = strconv.ParseFloat(c, 64)
+			if err != nil {
+				return tags, fmt.Errorf("%s confidence error: %w", tag.Address, err)
+			}
+		}
+		tags = append(tags, tag)
+	}
+}
+
+// tagLabels turns tags into labels of configured chains.
+// Returns the number of tags skipped for having no address, an unknown chain or nothing to say
+func tagLabels(tags []AddressTag, chains []Blockchain, source string, confidence float64) ([]Label, int) {
+	var labels []Label
+	skipped := 0
+	for _, tag := range tags {
+		chain, ok := tagChain(chains, tag.Chain)
+		ownerType := categoryOwnerTypes[strings.ToLower(strings.TrimSpace(tag.Category))]
+		if !ok || tag.Address == "" || (tag.Entity == "" && ownerType == "") {
+			skipped++
+			continue
+		}
+		l := Label{
+			Blockchain: chain.Name,
+			Address:    normalizeAddress(tag.Address),
+			Owner:      truncate(tag.Entity, 64),
+			OwnerType:  ownerType,
+			Source:     source,
+			Confidence: tag.Confidence,
+		}
+		if l.Confidence <= 0 || l.Confidence > 1 {
+			l.Confidence = confidence
+		}
+		labels = append(labels, l)
+	}
+	return labels, skipped
+}
+
+// tagChain finds a configured chain by name or symbol
+func tagChain(chains []Blockchain, text string) (Blockchain, bool) {
+	text = strings.TrimSpace(text)
+	if c, ok := findChain(chains, strings.ToLower(text)); ok {
+		return c, true
+	}
+	for _, c := range chains {
+		if strings.EqualFold(c.Symbol, text) {
+			return c, true
+		}
+	}
+	return Blockchain{}, false
+}
+
+// normalizeAddress lowercases hex addresses which are case insensitive.
+// Others like bitcoin's are kept as is
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(strings.ToLower(address), "0x") {
+		return strings.ToLower(address)
+	}
+	return address
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length])
+}
+
+// importLabels saves labels and applies them to known whales without waiting for a scrape.
+// A label from another source is only replaced by one at least as confident.
+// Returns the number of whales updated
+func importLabels(ctx context.Context, pool *pgxpool.Pool, namer entityNamer, labels []Label, source string) (int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for _, l := range labels {
+		entity := namer.entity(l.Owner)
+		if entity != "" {
+			batch.Queue(`
+				INSERT INTO entity (name) VALUES ($1)
+				ON CONFLICT ON CONSTRAINT ux_entity_name DO NOTHING;
+			`, entity)
+		}
+		batch.Queue(`
+			INSERT INTO label (blockchain, address, owner, owner_type, source, confidence, entity_id)
+			VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, (SELECT entity_id FROM entity WHERE name = NULLIF($7, '')))
+			ON CONFLICT ON CONSTRAINT ux_label_blockchain_address DO UPDATE
+			SET owner = EXCLUDED.owner, owner_type = EXCLUDED.owner_type,
+				source = EXCLUDED.source, confidence = EXCLUDED.confidence, entity_id = EXCLUDED.entity_id
+			WHERE label.source = EXCLUDED.source OR label.confidence <= EXCLUDED.confidence;
+		`, l.Blockchain, l.Address, l.Owner, l.OwnerType, l.Source, l.Confidence, entity)
+	}
+	results := tx.SendBatch(ctx, batch)
+	err = results.Close()
+	if err != nil {
+		return 0, fmt.Errorf("label insert error: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE whale w
+		SET owner = coalesce(l.owner, w.owner),
+			owner_type = coalesce(l.owner_type, w.owner_type),
+			entity_id = coalesce(l.entity_id, w.entity_id),
+			label_id = l.label_id
+		FROM label l
+		WHERE l.source = $1
+		AND l.blockchain = w.blockchain
+		AND lower(l.address) = lower(w.address)
+		AND NOT EXISTS (
+			SELECT 1 FROM whale_override o
+			WHERE o.blockchain = w.blockchain AND lower(o.address) = lower(w.address)
+		);
+	`, source)
+	if err != nil {
+		return 0, fmt.Errorf("whale label error: %w", err)
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTagsCSV(t *testing.T) {
+	tags, err := parseTagsCSV(strings.NewReader(`Chain,Address,Entity,Category,Confidence
+eth,0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8,Binance,Exchange,0.9
+bitcoin,34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo,Binance,cex,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AddressTag{
+		{Address: "0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8", Chain: "eth", Entity: "Binance", Category: "Exchange", Confidence: 0.9},
+		{Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Chain: "bitcoin", Entity: "Binance", Category: "cex"},
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %+v, want %+v", tags, want)
+	}
+
+	_, err = parseTagsCSV(strings.NewReader("entity,category\nBinance,exchange\n"))
+	if err == nil {
+		t.Error("expected missing column error")
+	}
+	_, err = parseTagsCSV(strings.NewReader("address,chain,confidence\n0x1,eth,high\n"))
+	if err == nil {
+		t.Error("expected confidence error")
+	}
+}
+
+func TestTagLabels(t *testing.T) {
+	chains := []Blockchain{bitcoin, ethereum}
+	labels, skipped := tagLabels([]AddressTag{
+		{Address: "0xBE0eB53F46cd790Cd13851d5EFf43D12404d33E8", Chain: "ETH", Entity: "Binance", Category: "Exchange", Confidence: 0.9},
+		{Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Chain: "Bitcoin", Entity: "Binance", Category: "cex"},
+		{Address: "0x00000000219ab540356cBB839Cbe05303d7705Fa", Chain: "ethereum", Category: "staking"},
+		{Address: "0x5754284F345afc66a98fbB0a0Afe71e0F007B949", Chain: "ethereum", Entity: "Tether: Treasury", Category: "stablecoin issuer"},
+		// unknown chain
+		{Address: "0x1", Chain: "solana", Entity: "Binance", Category: "exchange"},
+		// nothing to say
+		{Address: "0x2", Chain: "ethereum", Category: "phishing"},
+		{Chain: "ethereum", Entity: "Binance"},
+	}, chains, "etherscan-labels", 0.5)
+	if skipped != 3 {
+		t.Errorf("got %d skipped, want 3", skipped)
+	}
+	want := []Label{
+		{Blockchain: "ethereum", Address: "0xbe0eb53f46cd790cd13851d5eff43d12404d33e8", Owner: "Binance", OwnerType: "exchange", Source: "etherscan-labels", Confidence: 0.9},
+		{Blockchain: "bitcoin", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Owner: "Binance", OwnerType: "exchange", Source: "etherscan-labels", Confidence: 0.5},
+		{Blockchain: "ethereum", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", OwnerType: "stake", Source: "etherscan-labels", Confidence: 0.5},
+		{Blockchain: "ethereum", Address: "0x5754284f345afc66a98fbb0a0afe71e0f007b949", Owner: "Tether: Treasury", Source: "etherscan-labels", Confidence: 0.5},
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("got %+v, want %+v", labels, want)
+	}
+}
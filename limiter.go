@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RateLimit struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+// bucket is a token bucket. Callers reserve a token and wait until it is available
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit RateLimit) *bucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: limit.PerSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *bucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) Wait(ctx context.Context) error {
+	if b.rate <= 0 {
+		// unlimited
+		return ctx.Err()
+	}
+	return sleep(ctx, b.reserve())
+}
+
+// HostLimiter keeps a bucket per host so one slow site doesn't hold back another
+type HostLimiter struct {
+	mu       sync.Mutex
+	limits   map[string]RateLimit
+	fallback RateLimit
+	buckets  map[string]*bucket
+}
+
+// newHostLimiter limits hosts by their configured limits. The limit keyed by "default" applies to the rest
+func newHostLimiter(limits map[string]RateLimit) *HostLimiter {
+	l := &HostLimiter{
+		limits:   map[string]RateLimit{},
+		fallback: RateLimit{PerSecond: 3, Burst: 1},
+		buckets:  map[string]*bucket{},
+	}
+	for host, limit := range limits {
+		if host == "default" {
+			l.fallback = limit
+			continue
+		}
+		l.limits[strings.ToLower(host)] = limit
+	}
+	return l
+}
+
+// limit finds the limit of host or of its closest configured parent domain
+func (l *HostLimiter) limit(host string) (string, RateLimit) {
+	for h := host; h != ""; {
+		if limit, ok := l.limits[h]; ok {
+			return h, limit
+		}
+		i := strings.Index(h, ".")
+		if i < 0 {
+			break
+		}
+		h = h[i+1:]
+	}
+	return host, l.fallback
+}
+
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	key, limit := l.limit(strings.ToLower(host))
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(limit)
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+	return b.Wait(ctx)
+}
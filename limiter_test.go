@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(map[string]RateLimit{
+		"etherscan.io": {PerSecond: 20, Burst: 2},
+		"default":      {PerSecond: 0},
+	})
+	ctx := context.Background()
+	start := time.Now()
+	// burst of 2 then 4 more at 20 per second
+	for i := 0; i < 6; i++ {
+		err := l.Wait(ctx, "api.etherscan.io")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waited := time.Since(start); waited < 190*time.Millisecond {
+		t.Errorf("6 requests took %s, expected at least 200ms", waited)
+	}
+
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		l.Wait(ctx, "bitinfocharts.com")
+	}
+	if waited := time.Since(start); waited > 50*time.Millisecond {
+		t.Errorf("unlimited host waited %s", waited)
+	}
+}
+
+func TestHostLimiterCancel(t *testing.T) {
+	l := newHostLimiter(map[string]RateLimit{"default": {PerSecond: 0.1, Burst: 1}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	l.Wait(ctx, "etherscan.io")
+	err := l.Wait(ctx, "etherscan.io")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+}
@@ -13,11 +13,11 @@ import (
 	"math"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -30,6 +30,8 @@ type TokenContract struct {
 	Symbol     string `json:"symbol"`
 	Address    string `json:"address"`
 	Blockchain string `json:"blockchain"`
+	// Stablecoin overrides whether the token is pegged to the dollar. Defaults to symbols containing USD
+	Stablecoin *bool `json:"stablecoin,omitempty"`
 }
 
 type Wallet struct {
@@ -40,7 +42,11 @@ type Wallet struct {
 	Percentage float64
 	IsContract bool
 	OwnerType  string
-	Symbol     string
+	// Entity groups the wallets of one owner across chains. Empty if unknown
+	Entity string
+	Symbol string
+	// BlockHeight is the block the balance was read at. 0 if unknown
+	BlockHeight int64
 }
 
 type Series struct {
@@ -52,6 +58,13 @@ type Series struct {
 	PaperHands        float64 `json:"paper_hands,omitempty"`
 	DiamondHandsCount int     `json:"diamond_hands_count,omitempty"`
 	PaperHandsCount   int     `json:"paper_hands_count,omitempty"`
+	// Entities are the balances held by the exchange wallets of each entity
+	Entities map[string]float64 `json:"entities,omitempty"`
+	// Internal is what other tracked whales moved into exchanges since the previous batch,
+	// less what exchanges moved out to them, keyed by the bucket the whales were in
+	Internal map[string]float64 `json:"internal,omitempty"`
+	// Price is the usd price of the asset in the batch. 0 if unknown
+	Price float64 `json:"price,omitempty"`
 }
 
 type Config struct {
@@ -59,41 +72,133 @@ type Config struct {
 	Database string          `json:"pg_url"`
 	Output   string          `json:"output"`
 	Tokens   []TokenContract `json:"tokens"`
+	Chains   []Blockchain    `json:"chains"`
+	HTTP     HTTPConfig      `json:"http"`
+	// Sources picks how each blockchain is read. scrape (default), rpc or api
+	Sources     map[string]string `json:"sources"`
+	BitcoinRPC  RPCConfig         `json:"bitcoin_rpc"`
+	EthereumRPC RPCConfig         `json:"ethereum_rpc"`
+	// EtherscanAPI is used when a blockchain is read from the api
+	EtherscanAPI APIConfig `json:"etherscan_api"`
+	// Archive is the directory to keep scraped pages in
+	Archive string `json:"archive"`
+	// Rules classify scraped wallets in order
+	Rules []Rule `json:"rules"`
+	// Entities group owner names that aren't grouped well by default
+	Entities []EntityRule `json:"entities"`
+	// Membership is how whales that dropped off a rich list are counted. carry (default), exclude or none
+	Membership string `json:"membership"`
+	// Dormant alerts when long unchanged whales move
+	Dormant DormantConfig `json:"dormant"`
+	// Holders sets how cold and hot wallets are told apart
+	Holders HolderConfig `json:"holders"`
 }
 
 type TelegramConfig struct {
 	BotID       string `json:"bot_id"`
 	RecipientID string `json:"recipient_id"`
+	// TopExchanges is how many exchanges to list by net flow in each window. 0 to leave them out
+	TopExchanges int `json:"top_exchanges"`
 }
 
+// Point is the series of every asset captured in a batch
 type Point struct {
-	Date int64  `json:"date"`
-	Eth  Series `json:"eth,omitempty"`
-	Btc  Series `json:"btc,omitempty"`
-	USD  Series `json:"usd,omitempty"`
+	// Date is the batch time in unix seconds
+	Date int64 `json:"date"`
+	// Assets are the series of each coin and token keyed by Asset.Key
+	Assets map[string]Series `json:"assets"`
 }
 
 type contextKey int
-type chainID int
+
+// Blockchain is a chain whose whales are tracked
 type Blockchain struct {
-	ID    chainID
-	Price float64
+	// Name is stored as whale.blockchain
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+	// RichList is the site whose rich list is scraped. bitinfocharts or etherscan
+	RichList string `json:"rich_list,omitempty"`
+	// Explorer is the base url of an explorer with etherscan's layout like https://bscscan.com.
+	// Defaults to etherscan
+	Explorer string `json:"explorer,omitempty"`
+	// PriceID is the coingecko id. Defaults to the name
+	PriceID string `json:"price_id,omitempty"`
+	// PricePlatform is the coingecko asset platform of its tokens. Defaults to the name
+	PricePlatform string  `json:"price_platform,omitempty"`
+	Price         float64 `json:"price,omitempty"`
+	// RPC is the json-rpc node of an etherscan chain read with the rpc source. Defaults to ethereum_rpc for ethereum
+	RPC *RPCConfig `json:"rpc,omitempty"`
+	// API is the etherscan compatible api of an etherscan chain read with the api source like https://api.bscscan.com/api.
+	// Defaults to etherscan_api for ethereum
+	API *APIConfig `json:"api,omitempty"`
 }
 
 const (
 	chain contextKey = iota
 )
-const (
-	Bitcoin chainID = iota
-	Ethereum
+
+var (
+	bitcoin  = Blockchain{Name: "bitcoin", Symbol: "BTC", RichList: "bitinfocharts"}
+	ethereum = Blockchain{Name: "ethereum", Symbol: "ETH", RichList: "etherscan"}
 )
 
-func (b Blockchain) name() string {
-	return []string{"bitcoin", "ethereum"}[b.ID]
+func (b Blockchain) priceID() string {
+	if b.PriceID != "" {
+		return b.PriceID
+	}
+	return b.Name
+}
+
+func (b Blockchain) pricePlatform() string {
+	if b.PricePlatform != "" {
+		return b.PricePlatform
+	}
+	return b.Name
+}
+
+func (b Blockchain) explorer() string {
+	return baseURL(b.Explorer, etherscanExplorer)
+}
+
+// chainRPC is the node of an etherscan chain. false if it has none
+func (c Config) chainRPC(b Blockchain) (RPCConfig, bool) {
+	rpc := c.EthereumRPC
+	if b.RPC != nil {
+		rpc = *b.RPC
+	} else if b.Name != ethereum.Name {
+		return RPCConfig{}, false
+	}
+	return rpc, b.RichList == ethereum.RichList && rpc.URL != ""
+}
+
+// chainAPI is the etherscan compatible api of an etherscan chain. false if it has none.
+// Only ethereum defaults to the url of etherscan
+func (c Config) chainAPI(b Blockchain) (APIConfig, bool) {
+	api := c.EtherscanAPI
+	if b.API != nil {
+		api = *b.API
+	} else if b.Name != ethereum.Name {
+		return APIConfig{}, false
+	}
+	return api, b.RichList == ethereum.RichList && api.Key != "" && (api.URL != "" || b.Name == ethereum.Name)
+}
+
+// blockchains are the configured chains. Bitcoin and ethereum if there are none
+func (c Config) blockchains() []Blockchain {
+	if len(c.Chains) == 0 {
+		return []Blockchain{bitcoin, ethereum}
+	}
+	return c.Chains
 }
 
-func (b Blockchain) symbol() string {
-	return []string{"BTC", "ETH"}[b.ID]
+// findChain looks up a blockchain by name
+func findChain(chains []Blockchain, name string) (Blockchain, bool) {
+	for _, c := range chains {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Blockchain{}, false
 }
 
 const TGURL = "https://api.telegram.org"
@@ -103,6 +208,7 @@ func main() {
 	configPath := flag.String("c", "config.json", "config file")
 	pricePath := flag.String("p", "price.json", "price file")
 	shouldUpdate := flag.Bool("update", false, "flag to trigger batch update")
+	replayRun := flag.String("replay", "", "id of an archived run to parse again")
 	flag.Parse()
 	config := parseConfig(*configPath)
 
@@ -110,34 +216,117 @@ func main() {
 		fmt.Println("provide db")
 		return
 	}
+	if !validMembership(config.Membership) {
+		fmt.Printf("unknown membership %q. use carry, exclude or none\n", config.Membership)
+		return
+	}
 
 	defer fmt.Printf("execution took %d seconds\n", time.Now().Unix()-start)
 
-	ctx := context.Background()
-	blockchains := []Blockchain{{Bitcoin, 0}, {Ethereum, 0}}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pool, err := pgxpool.Connect(ctx, config.Database)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer pool.Close()
+	if flag.Arg(0) == "migrate" {
+		err = migrate(ctx, pool, flag.Args()[1:])
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	err = checkSchema(ctx, pool)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	namer, err := newEntityNamer(config.Entities)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if flag.Arg(0) == "import-labels" {
+		err = importLabelsCommand(ctx, pool, config.blockchains(), namer, flag.Args()[1:])
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	fetcher := newFetcher(config.HTTP)
+	archive := newArchive(config.Archive)
+	if flag.Arg(0) == "backfill-prices" {
+		err = backfillPricesCommand(ctx, pool, fetcher, config, flag.Args()[1:])
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	classifier, err := newClassifier(config.Rules, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	classifier.entities = namer
+	if *replayRun != "" {
+		err = replay(ctx, pool, archive, *replayRun, config.blockchains(), config.Tokens, classifier)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	blockchains := config.blockchains()
+	batchAt := time.Now().UTC().Truncate(time.Second)
 	if *shouldUpdate {
 		fmt.Println("updating")
-		err := batchUpdate(ctx, config.Database, blockchains, config.Tokens)
+		err := batchUpdate(ctx, pool, fetcher, archive, classifier, config, blockchains, batchAt)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 	}
 
-	pricedChains, err := fetchPrice(blockchains)
+	pricedChains, err := fetchPrice(ctx, fetcher, blockchains)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	points, err := generatePoints(ctx, config.Database)
+	assets := assetsFor(blockchains, config.Tokens)
+	tokenPrices, err := fetchTokenPrices(ctx, fetcher, assets)
+	if err != nil {
+		// stablecoins are still valued at their peg
+		fmt.Println(err)
+	}
+	assets = priceAssets(assets, pricedChains, tokenPrices)
+	if *shouldUpdate {
+		err = savePrices(ctx, pool, assets, batchAt)
+		if err != nil {
+			// the current prices are still used
+			fmt.Println(err)
+		}
+		err = alertAwakenings(ctx, pool, config, assets)
+		if err != nil {
+			// the summary is still useful
+			fmt.Println(err)
+		}
+	}
+
+	points, err := generatePoints(ctx, pool, config, assets)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
 	if config.Telegram.BotID != "" && config.Telegram.RecipientID != "" {
-		message := summarize(points, pricedChains)
+		message := summarize(points, assets)
+		if exchanges := summarizeExchanges(points, assets, config.Telegram.TopExchanges); exchanges != "" {
+			message += "\n\n" + exchanges
+		}
 		oldPrices := loadPrice(*pricePath)
 		priceMessage, silent := composePriceMessage(pricedChains, oldPrices)
 		message = fmt.Sprintf("[%s](https://enzosv.github.io/cryptowhales)\n\n%s", strings.Join(priceMessage, ", "), message)
@@ -150,7 +339,7 @@ func main() {
 	if config.Output == "" || !*shouldUpdate {
 		return
 	}
-	latest, err := json.Marshal(points)
+	latest, err := json.Marshal(newOutput(assets, points))
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -168,7 +357,7 @@ func composePriceMessage(pricedChains, oldPrices []Blockchain) ([]string, bool)
 	for _, c := range pricedChains {
 		var dif float64
 		for _, o := range oldPrices {
-			if o.ID == c.ID {
+			if o.Symbol == c.Symbol {
 				dif = (c.Price - o.Price) * 100 / ((c.Price + o.Price) / 2)
 				break
 			}
@@ -181,11 +370,19 @@ func composePriceMessage(pricedChains, oldPrices []Blockchain) ([]string, bool)
 			difMessage = fmt.Sprintf(" (%.2f)", dif)
 			silent = false
 		}
-		priceMessage = append(priceMessage, fmt.Sprintf("%s: %.1fK%s", c.symbol(), c.Price/1000, difMessage))
+		priceMessage = append(priceMessage, fmt.Sprintf("%s: %s%s", c.Symbol, formatPrice(c.Price), difMessage))
 	}
 	return priceMessage, silent
 }
 
+// formatPrice shortens prices in the thousands. Cheaper coins keep their cents
+func formatPrice(price float64) string {
+	if price >= 1000 {
+		return fmt.Sprintf("%.1fK", price/1000)
+	}
+	return fmt.Sprintf("%.2f", price)
+}
+
 func loadPrice(path string) []Blockchain {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -205,49 +402,39 @@ func logPrice(pricedChains []Blockchain, path string) error {
 	return ioutil.WriteFile(path, file, 0644)
 }
 
-func generatePoints(ctx context.Context, pg_url string) ([]Point, error) {
-	conn, err := pgx.Connect(ctx, pg_url)
+// generatePoints reads the series of every asset
+func generatePoints(ctx context.Context, pool *pgxpool.Pool, config Config, assets []Asset) ([]Point, error) {
+	c, err := pool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	ethseries, err := generate_eth_series(ctx, conn)
-	if err != nil {
-		return nil, fmt.Errorf("generate eth series error: %w", err)
-	}
-	btcseries, err := generate_btc_series(ctx, conn)
-	if err != nil {
-		return nil, fmt.Errorf("generate btc series error: %w", err)
-	}
-	usdseries, err := generate_usd_series(ctx, conn)
-	if err != nil {
-		return nil, fmt.Errorf("generate usd series error: %w", err)
-	}
-	var points []Point
-	// assumes eth has the all the dates
-	for _, p := range ethseries {
-		var point Point
-		point.Eth = p
-		point.Date = p.Date
-		points = append(points, point)
-	}
-	// TODO: Convert to map[date]series
-	for i, p := range points {
-		for _, b := range btcseries {
-			if b.Date != p.Date {
-				continue
-			}
-			points[i].Btc = b
-			break
+	defer c.Release()
+	conn := c.Conn()
+	assetseries := map[string][]Series{}
+	for _, a := range assets {
+		moves, err := generate_internal_flows(ctx, conn, a.Blockchain.Name, a.Symbol, config.Holders)
+		if err != nil {
+			return nil, fmt.Errorf("generate %s internal flows error: %w", a.Key, err)
 		}
-		for _, u := range usdseries {
-			if u.Date != p.Date {
-				continue
-			}
-			points[i].USD = u
-			break
+		series, err := generate_series(ctx, conn, a.Blockchain.Name, a.Symbol, config.Membership, config.Holders, moves)
+		if err != nil {
+			return nil, fmt.Errorf("generate %s series error: %w", a.Key, err)
+		}
+		totals, err := generate_entity_totals(ctx, conn, a.Blockchain.Name, a.Symbol, config.Membership, config.Holders.windowDays())
+		if err != nil {
+			return nil, fmt.Errorf("generate %s entity totals error: %w", a.Key, err)
 		}
+		prices, err := generate_prices(ctx, conn, a.Blockchain.Name, a.Symbol, config.Holders.windowDays())
+		if err != nil {
+			return nil, fmt.Errorf("generate %s prices error: %w", a.Key, err)
+		}
+		for i, s := range series {
+			series[i].Entities = totals[s.Date]
+			series[i].Price = priceAt(prices, s.Date)
+		}
+		assetseries[a.Key] = series
 	}
-	return points, nil
+	return mergePoints(assetseries), nil
 }
 
 func commit(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) error {
@@ -263,178 +450,61 @@ func commit(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) error {
 	return tx.Commit(ctx)
 }
 
-func btcUpdate(ctx context.Context, conn *pgxpool.Pool) error {
-	var wallets []Wallet
-	for i := 0; i < 40; i++ {
-		ws, err := scrapeBTC(i+1, 10, 300*time.Millisecond)
+func batchUpdate(pctx context.Context, pool *pgxpool.Pool, fetcher *Fetcher, archive *Archive, classifier Classifier, config Config, blockchains []Blockchain, batchAt time.Time) error {
+	u := updater{pool, fetcher, archive, batchAt, nil, classifier}
+	// every chain is checked before any is updated so a bad config doesn't leave a batch half done
+	sourcesOf := make([][]BalanceSource, len(blockchains))
+	for i, blockchain := range blockchains {
+		sources, err := balanceSources(config, fetcher, blockchain)
 		if err != nil {
 			return err
 		}
-		for i, wallet := range wallets {
-			for _, w := range ws {
-				if wallet.Address == w.Address {
-					// remove eariler duplicate
-					wallets[i] = Wallet{}
-				}
-			}
-		}
-		wallets = append(wallets, ws...)
+		sourcesOf[i] = sources
 	}
-	tx, err := conn.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-	batch := &pgx.Batch{}
-	logScrape(batch, wallets)
-	err = commit(ctx, tx, batch)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func ethUpdate(ctx context.Context, conn *pgxpool.Pool, tokens []TokenContract) error {
-	var wallets []Wallet
-	for i := 0; i < 100; i++ {
-		ws, err := scrapeEth(i+1, 10, 300*time.Millisecond)
-		if err != nil {
-			return err
-		}
-		for i, wallet := range wallets {
-			for _, w := range ws {
-				if wallet.Address == w.Address {
-					// remove eariler duplicate
-					wallets[i] = Wallet{}
+	// do each blockchain simultaniously
+	eg := new(errgroup.Group)
+	for i, blockchain := range blockchains {
+		blockchain := blockchain
+		sources := sourcesOf[i]
+		eg.Go(func() error {
+			ctx := context.WithValue(pctx, chain, blockchain.Name)
+			u := u
+			u.supplies = u.trackTokens(ctx, contractCallerFor(config, fetcher, blockchain, sources), blockchain.Name, config.Tokens)
+			for _, src := range sources {
+				err := u.refresh(ctx, src)
+				if err != nil {
+					return err
 				}
 			}
-		}
-		wallets = append(wallets, ws...)
-	}
-	tx, err := conn.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-	batch := &pgx.Batch{}
-
-	logScrape(batch, wallets)
-
-	err = commit(ctx, tx, batch)
-	if err != nil {
-		return err
-	}
-	for _, token := range tokens {
-		tx, err := conn.Begin(ctx)
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback(ctx)
-		batch := &pgx.Batch{}
-		var twallets []Wallet
-		for i := 0; i < 20; i++ {
-			ws, err := scrapeEthToken(token, i+1, 10, 300*time.Millisecond)
-			if err != nil {
-				return err
+			if len(sources) > 0 {
+				return nil
 			}
-			for i, wallet := range twallets {
-				for _, w := range ws {
-					if wallet.Address == w.Address {
-						// remove eariler duplicate
-						twallets[i] = Wallet{}
-					}
+			// native balances first then tokens
+			for _, s := range scrapersFor(blockchain, config.Tokens) {
+				err := u.update(ctx, s)
+				if err != nil {
+					return err
 				}
 			}
-			twallets = append(twallets, ws...)
-		}
-		logScrape(batch, twallets)
-
-		err = commit(ctx, tx, batch)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func batchUpdate(pctx context.Context, pg_url string, blockchains []Blockchain, tokens []TokenContract) error {
-	pool, err := pgxpool.Connect(pctx, pg_url)
-	if err != nil {
-		return err
-	}
-	defer pool.Close()
-	// do btc and eth simultaniously
-	eg := new(errgroup.Group)
-	for _, blockchain := range blockchains {
-
-		if blockchain.ID == Bitcoin {
-			eg.Go(func() error {
-				// do btc in background
-				ctx := context.WithValue(pctx, chain, blockchain.ID)
-				return btcUpdate(ctx, pool)
-			})
-		}
-		if blockchain.ID == Ethereum {
-			eg.Go(func() error {
-				// do eth in background
-				ctx := context.WithValue(pctx, chain, blockchain.ID)
-				var eth_tokens []TokenContract
-				for _, token := range tokens {
-					if token.Blockchain == blockchain.name() {
-						eth_tokens = append(eth_tokens, token)
-					}
-				}
-				return ethUpdate(ctx, pool, eth_tokens)
-			})
-		}
-	}
-
-	return eg.Wait()
-}
-
-func getDoc(url string, retries int, wait time.Duration) (*goquery.Document, error) {
-	time.Sleep(wait)
-	res, err := http.Get(url)
-	if err != nil {
-		fmt.Println(err)
-		if retries > 0 {
-			return getDoc(url, retries-1, wait)
-		}
-		return nil, err
-	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		fmt.Println("status code error")
-		if retries > 0 {
-			return getDoc(url, retries-1, wait)
-		}
-		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+			return nil
+		})
 	}
 
-	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		if retries > 0 {
-			return getDoc(url, retries-1, wait)
-		}
-		return nil, err
+	err := eg.Wait()
+	// other chains may have succeeded
+	if inferErr := inferBatchTransfers(pctx, pool, u.batchAt); inferErr != nil {
+		fmt.Println(inferErr)
 	}
-	return doc, nil
+	return err
 }
 
-func fetchPrice(chains []Blockchain) ([]Blockchain, error) {
+func fetchPrice(ctx context.Context, f *Fetcher, chains []Blockchain) ([]Blockchain, error) {
 	var ids []string
 	for _, chain := range chains {
-		ids = append(ids, chain.name())
+		ids = append(ids, chain.priceID())
 	}
 	request_url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=usd", strings.Join(ids, ","))
-	res, err := http.Get(request_url)
-	if err != nil {
-		return chains, err
-	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	body, err := f.Get(ctx, request_url)
 	if err != nil {
 		return chains, err
 	}
@@ -446,206 +516,36 @@ func fetchPrice(chains []Blockchain) ([]Blockchain, error) {
 
 	var pricedChains []Blockchain
 	for _, chain := range chains {
-		pricedChains = append(pricedChains, Blockchain{chain.ID, result[chain.name()]["usd"]})
+		chain.Price = result[chain.priceID()]["usd"]
+		pricedChains = append(pricedChains, chain)
 	}
 	return pricedChains, nil
 }
 
-// scrape
-
-// TODO: Create scrape protocol
-func scrapeEthToken(token TokenContract, page, retries int, wait time.Duration) ([]Wallet, error) {
-	url := fmt.Sprintf("https://etherscan.io/token/generic-tokenholders2?a=%s&p=%d", token.Address, page)
-	doc, err := getDoc(url, retries, wait)
-	if err != nil {
-		return nil, err
-	}
-	var wallets []Wallet
-	doc.Find("tr").Each(func(i int, s *goquery.Selection) {
-		var wallet Wallet
-		wallet.Symbol = token.Symbol
-		wallet.Blockchain = token.Blockchain
-		s.Find("td").Each(func(j int, ss *goquery.Selection) {
-
-			text := strings.ReplaceAll(ss.Text(), " ", "")
-
-			switch j {
-			case 1:
-				html, _ := ss.Html()
-				wallet.IsContract = strings.Contains(html, "Contract")
-				if strings.HasPrefix(text, "0x") {
-					wallet.Address = text
-				} else {
-					wallet.Name = text
-					split := strings.Split(html, fmt.Sprintf("%s?a=", token.Address))
-					split = strings.Split(split[1], " ")
-					wallet.Address = strings.TrimSuffix(split[0], `"`)
-				}
-
-			case 2:
-				bal := strings.ReplaceAll(text, ",", "")
-				f, err := strconv.ParseFloat(bal, 64)
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					wallet.Balance = f
-				}
-			}
-		})
-		// would have to manually edit on db if these assumptions are inaccurate
-		if wallet.Balance > 0 {
-			ownertype := "unknown"
-			if wallet.IsContract {
-				ownertype = "contract"
-			} else if wallet.Name != "" {
-				ownertype = "exchange"
-			}
-			wallet.OwnerType = ownertype
-			wallets = append(wallets, wallet)
-		}
-	})
-	if len(wallets) < 50 && retries > 0 {
-		return scrapeEthToken(token, page, retries-1, wait)
-	}
-	fmt.Println(url)
-	return wallets, nil
-}
-
-func scrapeBTC(page, retries int, wait time.Duration) ([]Wallet, error) {
-
-	url := fmt.Sprintf("https://bitinfocharts.com/top-100-richest-bitcoin-addresses-%d.html", page)
-	doc, err := getDoc(url, retries, wait)
-	if err != nil {
-		return nil, err
-	}
-	var wallets []Wallet
-	tables := []string{"#tblOne", "#tblOne2"}
-	for _, table := range tables {
-		doc.Find(table).Find("tr").Each(func(i int, s *goquery.Selection) {
-			var wallet Wallet
-			// TODO: Avoid hardcode
-			wallet.Symbol = "BTC"
-			wallet.Blockchain = "bitcoin"
-			wallet.IsContract = false
-			s.Find("td").Each(func(j int, ss *goquery.Selection) {
-				text := strings.ReplaceAll(ss.Text(), " ", "")
-				switch j {
-				case 1:
-					//Wallet
-					ss.Find("a").Each(func(k int, sss *goquery.Selection) {
-						t := strings.ReplaceAll(sss.Text(), " ", "")
-						switch k {
-						case 0:
-							wallet.Address = t
-						case 1:
-							wallet.Name = t
-						}
-					})
-				case 2:
-					//balance
-					bal := strings.Split(text, "BTC")[0]
-					bal = strings.ReplaceAll(bal, "BTC", "")
-					bal = strings.ReplaceAll(bal, ",", "")
-					f, err := strconv.ParseFloat(bal, 64)
-					if err != nil {
-						fmt.Println(err)
-					} else {
-						wallet.Balance = f
-					}
-				}
-			})
-			// would have to manually edit on db if these assumptions are inaccurate
-			if wallet.Balance > 0 {
-				ownertype := "unknown"
-				if wallet.Name != "" {
-					ownertype = "exchange"
-				}
-				wallet.OwnerType = ownertype
-				wallets = append(wallets, wallet)
-			}
-		})
-	}
-
-	if len(wallets) < 100 && retries > 0 {
-		return scrapeBTC(page, retries-1, wait)
-	}
-	fmt.Println(url)
-	return wallets, nil
-}
-
-func scrapeEth(page, retries int, wait time.Duration) ([]Wallet, error) {
-	url := fmt.Sprintf("https://etherscan.io/accounts/%d?ps=100", page)
-	doc, err := getDoc(url, retries, wait)
-	if err != nil {
-		return nil, err
-	}
-	var wallets []Wallet
-	doc.Find("tr").Each(func(i int, s *goquery.Selection) {
-		var wallet Wallet
-		// TODO: Avoid hardcode
-		wallet.Symbol = "ETH"
-		wallet.Blockchain = "ethereum"
-		s.Find("td").Each(func(j int, ss *goquery.Selection) {
-
-			text := strings.ReplaceAll(ss.Text(), " ", "")
-			switch j {
-			case 1:
-				html, _ := ss.Html()
-				wallet.IsContract = strings.Contains(html, "Contract")
-				wallet.Address = text
-			case 2:
-				wallet.Name = text
-			case 3:
-				bal := strings.ReplaceAll(text, "Ether", "")
-				bal = strings.ReplaceAll(bal, ",", "")
-				f, err := strconv.ParseFloat(bal, 64)
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					wallet.Balance = f
-				}
-				// case 4:
-				// percentage := strings.ReplaceAll(text, "%", "")
-				// f, err := strconv.ParseFloat(percentage, 64)
-				// if err != nil {
-				// 	log.Fatal(err)
-				// }
-				// wallet.Percentage = f
-			}
-		})
-		// would have to manually edit on db if these assumptions are inaccurate
-		if wallet.Balance > 0 {
-			ownertype := "unknown"
-			if wallet.IsContract {
-				ownertype = "contract"
-			} else if wallet.Name != "" {
-				ownertype = "exchange"
-			}
-			wallet.OwnerType = ownertype
-			wallets = append(wallets, wallet)
-		}
-	})
-	if len(wallets) < 100 && retries > 0 {
-		return scrapeEth(page, retries-1, wait)
-	}
-	fmt.Println(url)
-	return wallets, nil
-}
-
-func logScrape(batch *pgx.Batch, wallets []Wallet) {
+func logScrape(batch *pgx.Batch, wallets []Wallet, runID int, capturedAt time.Time) {
+	entityquery := `
+		INSERT INTO entity (name) VALUES ($1)
+		ON CONFLICT ON CONSTRAINT ux_entity_name DO NOTHING;
+	`
+	// curated owners and types in whale_override are never replaced
 	query := `
 		INSERT INTO whale
-		(blockchain, address, owner, owner_type, is_contract)
-		VALUES ($5, $1, NULLIF($2, ''), $3, $4)
+		(blockchain, address, owner, owner_type, is_contract, label_id, entity_id)
+		SELECT $5, $1, coalesce(o.owner, NULLIF($2, '')), coalesce(o.owner_type, $3), $4, l.label_id,
+			coalesce(l.entity_id, (SELECT entity_id FROM entity WHERE name = NULLIF($6, '')))
+		FROM (SELECT 1) w
+		LEFT JOIN whale_override o ON o.blockchain = $5 AND lower(o.address) = lower($1)
+		LEFT JOIN label l ON l.blockchain = $5 AND lower(l.address) = lower($1)
 		ON CONFLICT ON CONSTRAINT ux_blockchain_address DO UPDATE
-		SET owner = NULLIF($2, '');
+		SET owner = EXCLUDED.owner, owner_type = EXCLUDED.owner_type,
+			label_id = EXCLUDED.label_id, entity_id = EXCLUDED.entity_id;
 	`
 	balquery := `
 		INSERT INTO balance
-		(whale_id, value, symbol)
+		(whale_id, value, symbol, run_id, created_at, block_height, percentage)
 		VALUES (
 			(SELECT whale_id FROM whale WHERE address = $1 AND blockchain = $4), 
-			$2, $3
+			$2, $3, $5, $6, NULLIF($7, 0), NULLIF($8, 0)
 		);
 	`
 
@@ -653,236 +553,61 @@ func logScrape(batch *pgx.Batch, wallets []Wallet) {
 		if wallet.Balance <= 0 {
 			continue
 		}
-		batch.Queue(query, wallet.Address, wallet.Name, wallet.OwnerType, wallet.IsContract, wallet.Blockchain)
-		batch.Queue(balquery, wallet.Address, wallet.Balance, wallet.Symbol, wallet.Blockchain)
-	}
-}
-
-// generate series
-func generate_usd_series(ctx context.Context, conn *pgx.Conn) ([]Series, error) {
-	query := `
-	select 
-		coalesce(sum(b.value), 0) as exchange,
-		extract(epoch from date_trunc('hour', b.created_at)) as epoch
-	from balance b
-	join whale w using(whale_id)
-	where 
-		w.owner_type = 'exchange'
-		AND b.symbol like '%USD%' 
-		AND date_trunc('hour', b.created_at) >= to_timestamp(1641744000.000000)  --ignore values before full capture
-		AND date_trunc('hour', b.created_at) > now()-'31 days'::interval
-	group by  date_trunc('hour', b.created_at)
-	order by date_trunc('hour', b.created_at)
-	;
-	`
-	rows, err := conn.Query(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
-	}
-	defer rows.Close()
-	var data []Series
-	for rows.Next() {
-		if rows.Err() != nil {
-			return nil, fmt.Errorf("row error: %w", err)
+		if wallet.Entity != "" {
+			batch.Queue(entityquery, wallet.Entity)
 		}
-		var row Series
-		err := rows.Scan(
-			&row.Exchange, &row.Date,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
-		}
-		data = append(data, row)
+		batch.Queue(query, wallet.Address, wallet.Name, wallet.OwnerType, wallet.IsContract, wallet.Blockchain, wallet.Entity)
+		batch.Queue(balquery, wallet.Address, wallet.Balance, wallet.Symbol, wallet.Blockchain, runID, capturedAt, wallet.BlockHeight, wallet.Percentage)
 	}
-	return data, nil
 }
 
-func generate_btc_series(ctx context.Context, conn *pgx.Conn) ([]Series, error) {
-	query := `
-	select 
-		coalesce(sum(b.value) filter (where w.owner_type = 'exchange'), 0) as exchange,
-		sum(b.value) filter (where coalesce(b2.value, 0) <= b.value and not w.owner_type = 'exchange') as diamond_hands,
-		coalesce(sum(b.value) filter (where b2.value > b.value +1 and not w.owner_type = 'exchange'),0) as paper_hands,
-		count(b.value) filter (where coalesce(b2.value, 0) <= b.value and not w.owner_type = 'exchange') as diamond_hands_count,
-		coalesce(count(b.value) filter (where b2.value > b.value +1 and not w.owner_type = 'exchange'),0) as paper_hands_count,
-		extract(epoch from date_trunc('hour', b.created_at)) as epoch
-	from balance b
-	join whale w using(whale_id)
-	left outer join (
-		select b.whale_id, max(b.value) as value, min(b.created_at) as created_at
-		from balance b
-		INNER JOIN (
-			SELECT whale_id, MAX(value) as value 
-			FROM balance b
-			WHERE b.symbol = 'BTC'
-			AND b.created_at < now()-'1 hour'::interval 
-			AND b.created_at > now()-'61 days'::interval
-			GROUP BY whale_id
-		) l ON b.whale_id = l.whale_id AND b.value = l.value
-		WHERE b.symbol = 'BTC' 
-		group by b.whale_id
-	) b2 
-	on b2.whale_id = b.whale_id 
-		and b2.created_at <  b.created_at
-		--and b2.created_at > b.created_at-'31 days'::interval
-	where b.created_at > now()-'31 days'::interval
-	and b.symbol = 'BTC'
-	and w.blockchain = 'bitcoin'
-	group by  date_trunc('hour', b.created_at)
-	order by date_trunc('hour', b.created_at)
-	;
-	`
-	rows, err := conn.Query(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
-	}
-	defer rows.Close()
-	var data []Series
-	for rows.Next() {
-		if rows.Err() != nil {
-			return nil, fmt.Errorf("row error: %w", err)
-		}
-		var row Series
-		err := rows.Scan(
-			&row.Exchange,
-			&row.DiamondHands, &row.PaperHands,
-			&row.DiamondHandsCount, &row.PaperHandsCount,
-			&row.Date)
-		if err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
-		}
-		data = append(data, row)
-	}
-	return data, nil
+// hours ago of each window in the summary
+var milestones = map[string]int{
+	"1h":  1,
+	"4h":  4,
+	"24h": 24,
+	"7d":  168,
+	"30d": 720,
 }
 
-func generate_eth_series(ctx context.Context, conn *pgx.Conn) ([]Series, error) {
-	query := `
-	select 
-		coalesce(sum(b.value) filter (where w.owner_type = 'exchange'), 0) as exchange,
-		coalesce(sum(b.value) filter (where w.owner_type = 'wrap'), 0) as wrap,
-		coalesce(sum(b.value) filter (where w.owner_type = 'stake'), 0) as stake,
-		sum(b.value) filter (
-			where coalesce(b2.value, 0) <= b.value 
-			and not w.is_contract 
-			and w.owner_type not in ('exchange', 'stake', 'wrap', 'burn')
-		) as diamond_hands,
-		coalesce(sum(b.value) filter (where b2.value > b.value +1),0) as paper_hands,
-		count(b.value) filter (
-			where coalesce(b2.value, 0) <= b.value 
-			and not w.is_contract 
-			and w.owner_type not in ('exchange', 'stake', 'wrap', 'burn')
-		) as diamond_hands_count,
-		coalesce(count(b.value) filter (where b2.value > b.value +1),0) as paper_hands_count,
-		extract(epoch from date_trunc('hour', b.created_at)) as epoch
-	from balance b
-	join whale w using(whale_id)
-	left outer join (
-		select b.whale_id, max(b.value) as value, min(b.created_at) as created_at
-		from balance b
-		INNER JOIN (
-			SELECT b.whale_id, MAX(value) as value 
-			FROM balance b
-			join whale w using (whale_id)
-			where not w.is_contract 
-			and w.blockchain = 'ethereum'
-			and not w.owner_type in ('exchange', 'stake', 'wrap', 'burn')
-			and b.symbol = 'ETH'
-			and b.created_at < now()-'1 hour'::interval 
-			AND b.created_at > now()-'61 days'::interval
-			GROUP BY b.whale_id
-			having max(value) > min(value) --ignore illiquid
-		) l ON b.whale_id = l.whale_id AND b.value = l.value
-		group by b.whale_id
-	) b2 
-	on b2.whale_id = b.whale_id 
-		and b2.created_at < b.created_at
-		--and b2.created_at >= b.created_at-'31 days'::interval
-	where 
-		b.symbol = 'ETH' 
-		and not w.owner_type = 'burn'
-		AND b.created_at > now()-'31 days'::interval
-		and w.blockchain = 'ethereum'
-	group by  date_trunc('hour', b.created_at)
-	order by date_trunc('hour', b.created_at)
-	;
-	`
-	rows, err := conn.Query(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
-	}
-	defer rows.Close()
-	var data []Series
-	for rows.Next() {
-		if rows.Err() != nil {
-			return nil, fmt.Errorf("row error: %w", err)
-		}
-		var row Series
-		err := rows.Scan(
-			&row.Exchange, &row.Wrap, &row.Stake,
-			&row.DiamondHands, &row.PaperHands,
-			&row.DiamondHandsCount, &row.PaperHandsCount,
-			&row.Date)
-		if err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
-		}
-		data = append(data, row)
+// map iteration is random. force this order
+var milestoneKeys = []string{"1h", "4h", "24h", "7d", "30d"}
+
+// compact shortens large amounts. eg. 1.23M
+func compact(p *message.Printer, abs float64) string {
+	if abs >= 1000000000 {
+		return p.Sprintf("%.2fB", abs/1000000000)
+	} else if abs >= 1000000 {
+		return p.Sprintf("%.2fM", abs/1000000)
+	} else if abs >= 1000 {
+		return p.Sprintf("%.2fK", abs/1000)
 	}
-	return data, nil
+	return p.Sprintf("%.2f", abs)
 }
 
-func summarize(points []Point, blockchains []Blockchain) string {
-	if len(points) < 1 || len(blockchains) < 1 {
+func summarize(points []Point, assets []Asset) string {
+	if len(points) < 1 || len(assets) < 1 {
 		return ""
 	}
 	var differences []string
-	milestones := map[string]int{
-		"1h":  1,
-		"4h":  4,
-		"24h": 24,
-		"7d":  168,
-		"30d": 720,
-	}
-	// map iteration is random. force this order
-	keys := []string{"1h", "4h", "24h", "7d", "30d"}
 	latest := points[len(points)-1]
 	p := message.NewPrinter(language.English)
-	for _, k := range keys {
+	for _, k := range milestoneKeys {
 		m := milestones[k]
 		if len(points) < 1+m {
 			continue
 		}
 		msg := []string{fmt.Sprintf("*%s*:", k)}
 		point := points[len(points)-(1+m)]
-		overall := 0.0
-		for _, blockchain := range blockchains {
-			var new Series
-			var old Series
-			switch blockchain.ID {
-			case Bitcoin:
-				new = latest.Btc
-				old = point.Btc
-			case Ethereum:
-				new = latest.Eth
-				old = point.Eth
-			}
-			m, sum := analyze(new, old, blockchain.symbol(), false)
-			msg = append(msg, m...)
-			overall += sum * blockchain.Price
-		}
-		// TODO: Avoid hardcode
-		usdMessage, usdSum := analyze(latest.USD, point.USD, "USD", true)
-		msg = append(msg, usdMessage...)
-		overall += usdSum
-		var dif string
-		abs := math.Abs(overall)
-		if abs >= 1000000000 {
-			dif = p.Sprintf("%.2fB", abs/1000000000)
-		} else if abs >= 1000000 {
-			dif = p.Sprintf("%.2fM", abs/1000000)
-		} else if abs >= 1000 {
-			dif = p.Sprintf("%.2fK", abs/1000)
-		}
+		// valued when it happened rather than at today's price
+		overall := overallChange(points, assets, m)
+		for _, asset := range assets {
+			now := netted(latest.Assets[asset.Key], internalFlow(points, asset.Key, m), asset.Stablecoin)
+			old := netted(point.Assets[asset.Key], nil, asset.Stablecoin)
+			lines, _ := analyze(now, old, asset.Key, asset.Stablecoin)
+			msg = append(msg, lines...)
+		}
+		dif := compact(p, math.Abs(overall))
 		if overall > 0 {
 			msg[0] = fmt.Sprintf("*%s*: *+$%s*", k, dif)
 		} else if overall < 0 {
@@ -893,6 +618,15 @@ func summarize(points []Point, blockchains []Blockchain) string {
 	return strings.Join(differences, "\n")
 }
 
+// bullish is how much a flow into exchanges suggests buying.
+// Crypto entering exchanges is a negative and stablecoins entering them is a positive
+func bullish(exchangeFlow float64, stablecoin bool) float64 {
+	if stablecoin {
+		return exchangeFlow
+	}
+	return -exchangeFlow
+}
+
 func analyze(now, old Series, symbol string, is_stablecoin bool) ([]string, float64) {
 	if old.Exchange == 0 {
 		// assume empty if exchange is empty
@@ -905,15 +639,13 @@ func analyze(now, old Series, symbol string, is_stablecoin bool) ([]string, floa
 	var odividend float64
 	var odivisor float64
 
-	overall := (now.DiamondHands - old.DiamondHands) + (now.Stake - old.Stake)
+	overall := (now.DiamondHands - old.DiamondHands) + (now.Stake - old.Stake) + bullish(now.Exchange-old.Exchange, is_stablecoin)
 	if is_stablecoin {
 		odividend = 100 * ((now.DiamondHands + now.Stake - now.Exchange) - (old.DiamondHands + old.Stake - old.Exchange))
 		odivisor = ((now.DiamondHands + now.Stake - now.Exchange) + (old.DiamondHands + old.Stake - old.Exchange)) / 2
-		overall += (now.Exchange - old.Exchange)
 	} else {
 		odividend = 100 * ((now.DiamondHands + now.Stake + now.Exchange) - (old.DiamondHands + old.Stake + old.Exchange))
 		odivisor = ((now.DiamondHands + now.Stake + now.Exchange) + (old.DiamondHands + old.Stake + old.Exchange)) / 2
-		overall -= (now.Exchange - old.Exchange)
 	}
 	odif := odividend / odivisor
 
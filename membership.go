@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// How the series treat whales missing from a rich list.
+const (
+	// membershipCarry keeps counting whales that dropped off a list at their last balance (default)
+	membershipCarry = "carry"
+	// membershipExclude leaves out whales that entered or left a list in the window of the series
+	membershipExclude = "exclude"
+	// membershipNone counts only the balances that were scraped so dropped whales look emptied
+	membershipNone = "none"
+)
+
+// reportLimit is how many entered and exited whales are listed per run
+const reportLimit = 5
+
+// MembershipReport lists the whales that appeared on or dropped off a rich list in a run
+type MembershipReport struct {
+	Run     Run
+	Entered []Wallet
+	Exited  []Wallet
+	// EnteredCount and ExitedCount include whales beyond the listed ones
+	EnteredCount int
+	ExitedCount  int
+}
+
+// logMembership opens memberships of whales new to the list and closes those of whales missing from the run.
+// Queue it after the balances of the run.
+// The first run of a list is marked initial since its whales did not just enter
+func logMembership(batch *pgx.Batch, run Run) {
+	batch.Queue(`
+		INSERT INTO whale_membership (whale_id, blockchain, source, symbol, entered_at, entered_run_id, initial)
+		SELECT b.whale_id, $2, $3, $4, $5, $1, NOT EXISTS (
+			SELECT 1 FROM whale_membership m
+			WHERE m.blockchain = $2 AND m.source = $3 AND m.symbol = $4
+		)
+		FROM balance b
+		WHERE b.run_id = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM whale_membership m
+			WHERE m.whale_id = b.whale_id AND m.source = $3 AND m.symbol = $4 AND m.exited_at IS NULL
+		);
+	`, run.ID, run.Chain, run.Source, run.Symbol, run.BatchAt)
+	batch.Queue(`
+		UPDATE whale_membership m
+		SET exited_at = $5, exited_run_id = $1, last_value = (
+			SELECT b.value
+			FROM balance b
+			JOIN scrape_run r USING (run_id)
+			WHERE b.whale_id = m.whale_id AND b.symbol = $4 AND r.status = 'success' AND r.run_id <> $1
+			ORDER BY r.batch_at DESC
+			LIMIT 1
+		)
+		WHERE m.blockchain = $2 AND m.source = $3 AND m.symbol = $4
+		AND m.exited_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM balance b WHERE b.run_id = $1 AND b.whale_id = m.whale_id);
+	`, run.ID, run.Chain, run.Source, run.Symbol, run.BatchAt)
+}
+
+// membershipChanges reads the whales that entered and exited the list in a run
+func membershipChanges(ctx context.Context, pool *pgxpool.Pool, run Run) (MembershipReport, error) {
+	report := MembershipReport{Run: run}
+	rows, err := pool.Query(ctx, `
+		SELECT w.address, coalesce(w.owner, ''), m.entered_run_id = $1
+		FROM whale_membership m
+		JOIN whale w USING (whale_id)
+		LEFT JOIN balance b ON b.run_id = $1 AND b.whale_id = m.whale_id
+		WHERE (m.entered_run_id = $1 AND NOT m.initial) OR m.exited_run_id = $1
+		ORDER BY coalesce(b.value, m.last_value, 0) DESC, w.address;
+	`, run.ID)
+	if err != nil {
+		return report, fmt.Errorf("membership query error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wallet Wallet
+		var entered bool
+		err := rows.Scan(&wallet.Address, &wallet.Name, &entered)
+		if err != nil {
+			return report, fmt.Errorf("membership scan error: %w", err)
+		}
+		if entered {
+			report.EnteredCount++
+			if len(report.Entered) < reportLimit {
+				report.Entered = append(report.Entered, wallet)
+			}
+			continue
+		}
+		report.ExitedCount++
+		if len(report.Exited) < reportLimit {
+			report.Exited = append(report.Exited, wallet)
+		}
+	}
+	return report, rows.Err()
+}
+
+func (r MembershipReport) String() string {
+	if r.EnteredCount == 0 && r.ExitedCount == 0 {
+		return ""
+	}
+	lines := []string{fmt.Sprintf("%s %s: %d whales entered, %d exited", r.Run.Source, r.Run.Symbol, r.EnteredCount, r.ExitedCount)}
+	describe := func(prefix string, wallets []Wallet, count int) {
+		for _, w := range wallets {
+			name := w.Address
+			if w.Name != "" {
+				name = fmt.Sprintf("%s (%s)", w.Address, w.Name)
+			}
+			lines = append(lines, fmt.Sprintf("\t%s %s", prefix, name))
+		}
+		if count > len(wallets) {
+			lines = append(lines, fmt.Sprintf("\t%s %d more", prefix, count-len(wallets)))
+		}
+	}
+	describe("+", r.Entered, r.EnteredCount)
+	describe("-", r.Exited, r.ExitedCount)
+	return strings.Join(lines, "\n")
+}
+
+// balanceRows selects the balances the series are made of.
+// Columns are whale_id, value, symbol, run_id and created_at. $1 is the symbol, $2 the blockchain and $3 the window in days
+func balanceRows(membership string) string {
+	switch membership {
+	case membershipNone:
+		return `balance`
+	case membershipExclude:
+		return `(
+		select b.whale_id, b.value, b.symbol, b.run_id, b.created_at
+		from balance b
+		where b.symbol = $1
+		and not exists (
+			select 1 from whale_membership m
+			where m.whale_id = b.whale_id
+			and m.symbol = $1
+			and m.blockchain = $2
+			and (
+				m.exited_at > now() - make_interval(days => $3::int)
+				or (m.entered_at > now() - make_interval(days => $3::int) and not m.initial)
+			)
+		)
+	)`
+	}
+	// whales that entered again are carried by their latest membership until then
+	return `(
+		select b.whale_id, b.value, b.symbol, b.run_id, b.created_at
+		from balance b
+		where b.symbol = $1
+		union all
+		select m.whale_id, m.last_value, m.symbol, r.run_id, r.batch_at
+		from whale_membership m
+		join scrape_run r on r.blockchain = m.blockchain and r.source = m.source and r.symbol = m.symbol
+		where m.symbol = $1
+		and m.blockchain = $2
+		and m.last_value is not null
+		and m.exited_at > now() - make_interval(days => $3::int)
+		and r.batch_at >= m.exited_at
+		and r.status = 'success'
+		and not exists (
+			select 1 from whale_membership later
+			where later.whale_id = m.whale_id
+			and later.source = m.source
+			and later.symbol = m.symbol
+			and later.entered_at > m.entered_at
+			and later.entered_at <= r.batch_at
+		)
+	)`
+}
+
+// validMembership is true for the modes balanceRows knows. Empty means carry
+func validMembership(membership string) bool {
+	switch membership {
+	case "", membershipCarry, membershipExclude, membershipNone:
+		return true
+	}
+	return false
+}
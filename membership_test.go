@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func TestMembershipReport(t *testing.T) {
+	report := MembershipReport{
+		Run:          Run{Source: "bitinfocharts", Symbol: "BTC"},
+		Entered:      []Wallet{{Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet"}},
+		EnteredCount: 1,
+		ExitedCount:  7,
+	}
+	for i := 0; i < reportLimit; i++ {
+		report.Exited = append(report.Exited, Wallet{Address: fmt.Sprintf("1address%d", i)})
+	}
+	want := strings.Join([]string{
+		"bitinfocharts BTC: 1 whales entered, 7 exited",
+		"\t+ 34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo (Binance-coldwallet)",
+		"\t- 1address0",
+		"\t- 1address1",
+		"\t- 1address2",
+		"\t- 1address3",
+		"\t- 1address4",
+		"\t- 2 more",
+	}, "\n")
+	if got := report.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := (MembershipReport{}).String(); got != "" {
+		t.Errorf("got %q for no changes", got)
+	}
+}
+
+func TestBalanceRows(t *testing.T) {
+	if got := balanceRows(membershipNone); got != "balance" {
+		t.Errorf("none reads %q", got)
+	}
+	if got := balanceRows(""); got != balanceRows(membershipCarry) || !strings.Contains(got, "last_value") {
+		t.Error("carry is not the default")
+	}
+	if !strings.Contains(balanceRows(membershipExclude), "not exists") {
+		t.Error("exclude does not leave out whales")
+	}
+	if validMembership("forget") {
+		t.Error("unknown membership is valid")
+	}
+}
+
+// saveRun saves balances and memberships of a run like update does
+func saveRun(t *testing.T, pool *pgxpool.Pool, run Run, balances map[int]float64) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for whaleID, value := range balances {
+		batch.Queue(`
+			INSERT INTO balance (whale_id, value, symbol, run_id, created_at)
+			VALUES ($1, $2, $3, $4, $5);
+		`, whaleID, value, run.Symbol, run.ID, run.BatchAt)
+	}
+	logMembership(batch, run)
+	err = commit(ctx, tx, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	a := insertWhale(t, pool, "bitcoin", "a", "unknown", false)
+	b := insertWhale(t, pool, "bitcoin", "b", "unknown", false)
+	c := insertWhale(t, pool, "bitcoin", "c", "unknown", false)
+	start := time.Now().Truncate(time.Second).Add(-330 * time.Minute)
+	var runs []Run
+	for i, balances := range []map[int]float64{
+		{a: 10, b: 20},
+		// b drops off the list
+		{a: 10},
+		{a: 10, c: 5},
+		// b comes back
+		{a: 10, b: 25, c: 5},
+	} {
+		run := insertRun(t, pool, "bitinfocharts", "bitcoin", "BTC", start.Add(time.Duration(i)*time.Hour))
+		saveRun(t, pool, run, balances)
+		runs = append(runs, run)
+	}
+
+	reports := []MembershipReport{
+		// whales of the first run did not just enter
+		{Run: runs[0]},
+		{Run: runs[1], Exited: []Wallet{{Address: "b"}}, ExitedCount: 1},
+		{Run: runs[2], Entered: []Wallet{{Address: "c"}}, EnteredCount: 1},
+		{Run: runs[3], Entered: []Wallet{{Address: "b"}}, EnteredCount: 1},
+	}
+	for i, want := range reports {
+		got, err := membershipChanges(ctx, pool, runs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("run %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	type total struct {
+		value float64
+		count int
+	}
+	tests := map[string][]total{
+		// b is counted at its last balance until it comes back
+		membershipCarry: {{30, 2}, {30, 2}, {35, 3}, {40, 3}},
+		// b left and c entered in the window
+		membershipExclude: {{10, 1}, {10, 1}, {10, 1}, {10, 1}},
+		membershipNone:    {{30, 2}, {10, 1}, {15, 2}, {40, 3}},
+	}
+	for membership, want := range tests {
+		series, err := generate_series(ctx, testConn(t, pool), "bitcoin", "BTC", membership, HolderConfig{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []total
+		for _, s := range series {
+			got = append(got, total{s.DiamondHands, s.DiamondHandsCount})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", membership, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// schemaVersion is the migration the queries in this binary need.
+// Bump it whenever a query depends on a new migration
+const schemaVersion int64 = 20261017000010
+
+//go:embed db/migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads embedded migrations named like golang-migrate's <version>_<name>.<up|down>.sql
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("db/migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		parts := strings.SplitN(strings.TrimSuffix(name, ".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected migration file %s: %w", name, err)
+		}
+		content, err := migrationFiles.ReadFile(path.Join("db/migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(parts[1], ".up"):
+			m.Name = strings.TrimSuffix(parts[1], ".up")
+			m.Up = string(content)
+		case strings.HasSuffix(parts[1], ".down"):
+			m.Down = string(content)
+		default:
+			return nil, fmt.Errorf("migration %s is neither up nor down", name)
+		}
+	}
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both up and down files", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// schema_migrations is shared with golang-migrate so databases migrated with it are recognized
+func currentVersion(ctx context.Context, conn *pgxpool.Pool) (int64, bool, error) {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		);
+	`)
+	if err != nil {
+		return 0, false, err
+	}
+	var version int64
+	var dirty bool
+	err = conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1;`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func setVersion(ctx context.Context, tx pgx.Tx, version int64) error {
+	_, err := tx.Exec(ctx, `DELETE FROM schema_migrations;`)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false);`, version)
+	return err
+}
+
+// applyMigration runs sql and records the new version in one transaction
+func applyMigration(ctx context.Context, conn *pgxpool.Pool, sql string, version int64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+	err = setVersion(ctx, tx, version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func migrate(ctx context.Context, conn *pgxpool.Pool, args []string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	current, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("schema version error: %w", err)
+	}
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if dirty && command != "force" && command != "status" {
+		return fmt.Errorf("schema version %d is dirty. fix it manually then run migrate force <version>", current)
+	}
+	switch command {
+	case "status":
+		fmt.Printf("schema version: %d (dirty: %t, required: %d)\n", current, dirty, schemaVersion)
+		for _, m := range migrations {
+			state := "applied"
+			if m.Version > current {
+				state = "pending"
+			}
+			fmt.Printf("\t%d %s: %s\n", m.Version, m.Name, state)
+		}
+		return nil
+	case "up":
+		for _, m := range migrations {
+			if m.Version <= current {
+				continue
+			}
+			err = applyMigration(ctx, conn, m.Up, m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s error: %w", m.Version, m.Name, err)
+			}
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		return nil
+	case "down":
+		// only undo the latest migration. down is destructive
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version != current {
+				continue
+			}
+			var previous int64
+			if i > 0 {
+				previous = migrations[i-1].Version
+			}
+			err = applyMigration(ctx, conn, m.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d %s error: %w", m.Version, m.Name, err)
+			}
+			fmt.Printf("reverted %d %s\n", m.Version, m.Name)
+			return nil
+		}
+		return fmt.Errorf("no migration to revert from version %d", current)
+	case "force":
+		// for databases set up by hand
+		if len(args) < 2 {
+			return errors.New("usage: migrate force <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		return applyMigration(ctx, conn, "SELECT 1;", version)
+	}
+	return fmt.Errorf("unknown migrate command %q. use up, down, status or force", command)
+}
+
+// checkSchema refuses to continue if the database is missing migrations the queries need
+func checkSchema(ctx context.Context, conn *pgxpool.Pool) error {
+	current, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("schema version error: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", current)
+	}
+	if current < schemaVersion {
+		return fmt.Errorf("schema version %d is behind %d. run migrate up", current, schemaVersion)
+	}
+	return nil
+}
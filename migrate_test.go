@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("migration %d is out of order", migrations[i].Version)
+		}
+	}
+	if latest := migrations[len(migrations)-1].Version; latest < schemaVersion {
+		t.Errorf("required schema version %d has no migration. latest is %d", schemaVersion, latest)
+	}
+}
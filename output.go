@@ -0,0 +1,85 @@
+package main
+
+import "sort"
+
+// outputVersion changes whenever the shape of Output does so readers can tell old files apart.
+// 1 was a plain list of points with eth, btc and usd series. 2 had no price in series.
+// 3 had internal flows as one amount instead of by bucket. 4 had no overall changes
+const outputVersion = 5
+
+// Output is the json summary read by the website
+type Output struct {
+	Version int           `json:"version"`
+	Assets  []OutputAsset `json:"assets"`
+	// Points are ordered by date. An asset is missing from points where it was not captured
+	Points []Point `json:"points"`
+	// Changes are the overall usd change of every window the summary shows
+	Changes []OutputChange `json:"changes"`
+}
+
+// OutputChange is the overall usd change of every asset over the last hours
+type OutputChange struct {
+	Hours int     `json:"hours"`
+	USD   float64 `json:"usd"`
+}
+
+type OutputAsset struct {
+	Key        string `json:"key"`
+	Symbol     string `json:"symbol"`
+	Blockchain string `json:"blockchain"`
+	// Address of the token contract. Empty for native coins
+	Address    string  `json:"address,omitempty"`
+	Stablecoin bool    `json:"stablecoin,omitempty"`
+	Price      float64 `json:"price"`
+	// PriceID is the coingecko id of native coins
+	PriceID string `json:"price_id,omitempty"`
+}
+
+func newOutput(assets []Asset, points []Point) Output {
+	output := Output{Version: outputVersion, Points: points, Changes: []OutputChange{}}
+	for _, a := range assets {
+		oa := OutputAsset{
+			Key:        a.Key,
+			Symbol:     a.Symbol,
+			Blockchain: a.Blockchain.Name,
+			Address:    a.Address,
+			Stablecoin: a.Stablecoin,
+			Price:      a.Price,
+		}
+		if a.Address == "" {
+			oa.PriceID = a.Blockchain.priceID()
+		}
+		output.Assets = append(output.Assets, oa)
+	}
+	for _, k := range milestoneKeys {
+		m := milestones[k]
+		if len(points) < 1+m {
+			continue
+		}
+		output.Changes = append(output.Changes, OutputChange{Hours: m, USD: overallChange(points, assets, m)})
+	}
+	return output
+}
+
+// mergePoints groups the series of each asset by date
+func mergePoints(assetseries map[string][]Series) []Point {
+	byDate := map[int64]*Point{}
+	for key, series := range assetseries {
+		for _, s := range series {
+			point, ok := byDate[s.Date]
+			if !ok {
+				point = &Point{Date: s.Date, Assets: map[string]Series{}}
+				byDate[s.Date] = point
+			}
+			point.Assets[key] = s
+		}
+	}
+	points := make([]Point, 0, len(byDate))
+	for _, point := range byDate {
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Date < points[j].Date
+	})
+	return points
+}
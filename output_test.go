@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePoints(t *testing.T) {
+	points := mergePoints(map[string][]Series{
+		"ETH": {{Date: 3600, Exchange: 1}, {Date: 10800, Exchange: 3}},
+		// btc missed the second batch and eth the third
+		"BTC":  {{Date: 3600, Exchange: 10}, {Date: 7200, Exchange: 20}, {Date: 10800, Exchange: 30}},
+		"LINK": {{Date: 7200, Exchange: 200}},
+	})
+	want := []Point{
+		{Date: 3600, Assets: map[string]Series{"ETH": {Date: 3600, Exchange: 1}, "BTC": {Date: 3600, Exchange: 10}}},
+		{Date: 7200, Assets: map[string]Series{"BTC": {Date: 7200, Exchange: 20}, "LINK": {Date: 7200, Exchange: 200}}},
+		{Date: 10800, Assets: map[string]Series{"ETH": {Date: 10800, Exchange: 3}, "BTC": {Date: 10800, Exchange: 30}}},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Errorf("got %+v, want %+v", points, want)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	assets := []Asset{
+		{Key: "ETH", Blockchain: ethereum, Symbol: "ETH", Price: 3000},
+		{Key: "USDT", Blockchain: ethereum, Symbol: "USDT", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Price: 1, Stablecoin: true},
+	}
+	points := []Point{
+		{Date: 3600, Assets: map[string]Series{"ETH": {Date: 3600, Exchange: 1.5, Price: 2900}}},
+		// eth left exchanges at the batch price
+		{Date: 7200, Assets: map[string]Series{"ETH": {Date: 7200, Exchange: 0.5, Price: 3100}}},
+	}
+	content, err := json.Marshal(newOutput(assets, points))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":5,"assets":[` +
+		`{"key":"ETH","symbol":"ETH","blockchain":"ethereum","price":3000,"price_id":"ethereum"},` +
+		`{"key":"USDT","symbol":"USDT","blockchain":"ethereum","address":"0xdac17f958d2ee523a2206206994597c13d831ec7","stablecoin":true,"price":1}],` +
+		`"points":[{"date":3600,"assets":{"ETH":{"exchange":1.5,"price":2900}}},{"date":7200,"assets":{"ETH":{"exchange":0.5,"price":3100}}}],` +
+		`"changes":[{"hours":1,"usd":3100}]}`
+	if string(content) != want {
+		t.Errorf("got %s\nwant %s", content, want)
+	}
+}
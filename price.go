@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// pricePoint is the usd price of an asset at a batch or a backfilled hour
+type pricePoint struct {
+	// Date is the batch time or the start of the hour in unix seconds
+	Date int64
+	USD  float64
+}
+
+// savePrices keeps the current price of every asset in the price table for the batch at batchAt
+// so series are valued at the price of their own batch
+func savePrices(ctx context.Context, pool *pgxpool.Pool, assets []Asset, batchAt time.Time) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("save prices error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for _, a := range assets {
+		if a.Price == 0 {
+			continue
+		}
+		batch.Queue(`
+			INSERT INTO price (blockchain, symbol, bucket_at, usd)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT ON CONSTRAINT ux_price_asset_bucket DO UPDATE
+			SET usd = EXCLUDED.usd;
+		`, a.Blockchain.Name, a.Symbol, batchAt, a.Price)
+	}
+	err = commit(ctx, tx, batch)
+	if err != nil {
+		return fmt.Errorf("save prices error: %w", err)
+	}
+	return nil
+}
+
+// backfillPricesCommand fills the price table with past hourly prices from coingecko.
+// Prices saved by runs are kept.
+// usage: backfill-prices [-days 31]
+func backfillPricesCommand(ctx context.Context, pool *pgxpool.Pool, f *Fetcher, config Config, args []string) error {
+	flags := flag.NewFlagSet("backfill-prices", flag.ContinueOnError)
+	days := flags.Int("days", config.Holders.windowDays(), "days of prices to fetch. coingecko is hourly up to 90")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assetsFor(config.blockchains(), config.Tokens) {
+		prices, err := fetchPriceHistory(ctx, f, asset, *days)
+		if err != nil {
+			// coingecko may not list every token
+			fmt.Printf("%s price history error: %v\n", asset.Key, err)
+			continue
+		}
+		err = backfillPrices(ctx, pool, asset, prices)
+		if err != nil {
+			return fmt.Errorf("%s backfill error: %w", asset.Key, err)
+		}
+		fmt.Printf("%s: backfilled %d hourly prices\n", asset.Key, len(prices))
+	}
+	return nil
+}
+
+// fetchPriceHistory reads the usd prices of an asset over the last days from coingecko
+func fetchPriceHistory(ctx context.Context, f *Fetcher, asset Asset, days int) ([]pricePoint, error) {
+	path := url.PathEscape(asset.Blockchain.priceID())
+	if asset.Address != "" {
+		path = fmt.Sprintf("%s/contract/%s", url.PathEscape(asset.Blockchain.pricePlatform()), strings.ToLower(asset.Address))
+	}
+	requestURL := fmt.Sprintf("https://api.coingecko.com/api/v3/coins/%s/market_chart?vs_currency=usd&days=%d", path, days)
+	body, err := f.Get(ctx, requestURL)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		// pairs of unix milliseconds and price
+		Prices [][2]float64 `json:"prices"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	return hourlyPrices(result.Prices), nil
+}
+
+// hourlyPrices buckets coingecko prices by the hour. The latest price in an hour wins
+func hourlyPrices(raw [][2]float64) []pricePoint {
+	byHour := map[int64]pricePoint{}
+	latest := map[int64]float64{}
+	for _, p := range raw {
+		ms, usd := p[0], p[1]
+		if usd <= 0 {
+			continue
+		}
+		hour := int64(ms/1000) / 3600 * 3600
+		if _, ok := byHour[hour]; ok && latest[hour] > ms {
+			continue
+		}
+		byHour[hour] = pricePoint{Date: hour, USD: usd}
+		latest[hour] = ms
+	}
+	prices := make([]pricePoint, 0, len(byHour))
+	for _, p := range byHour {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Date < prices[j].Date
+	})
+	return prices
+}
+
+// backfillPrices saves prices for hours that don't have one yet
+func backfillPrices(ctx context.Context, pool *pgxpool.Pool, asset Asset, prices []pricePoint) error {
+	if len(prices) == 0 {
+		return nil
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for _, p := range prices {
+		batch.Queue(`
+			INSERT INTO price (blockchain, symbol, bucket_at, usd)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT ON CONSTRAINT ux_price_asset_bucket DO NOTHING;
+		`, asset.Blockchain.Name, asset.Symbol, time.Unix(p.Date, 0).UTC(), p.USD)
+	}
+	return commit(ctx, tx, batch)
+}
+
+// generate_prices reads the prices of an asset in the window and the day before it ordered by date
+func generate_prices(ctx context.Context, conn *pgx.Conn, blockchain, symbol string, days int) ([]pricePoint, error) {
+	query := `
+	select
+		extract(epoch from bucket_at)::bigint as epoch,
+		usd
+	from price
+	where bucket_at > now() - make_interval(days => $3::int + 1)
+	and symbol = $1
+	and blockchain = $2
+	order by bucket_at
+	;
+	`
+	rows, err := conn.Query(ctx, query, symbol, blockchain, days)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+	var prices []pricePoint
+	for rows.Next() {
+		var p pricePoint
+		err := rows.Scan(&p.Date, &p.USD)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// priceAt is the latest price at or before date. 0 if there is none
+func priceAt(prices []pricePoint, date int64) float64 {
+	i := sort.Search(len(prices), func(i int) bool {
+		return prices[i].Date > date
+	})
+	if i == 0 {
+		return 0
+	}
+	return prices[i-1].USD
+}
+
+// seriesPrice is the price of an asset in the batch of a series. The current price if it is unknown
+func seriesPrice(s Series, asset Asset) float64 {
+	if s.Price == 0 {
+		return asset.Price
+	}
+	return s.Price
+}
+
+// usdChange values the net change of an asset over the last hours at its price in each batch the change happened.
+// Batches without a price use the current price. 0 if the asset wasn't captured at the start
+func usdChange(points []Point, asset Asset, hours int) float64 {
+	if len(points) < 1+hours {
+		return 0
+	}
+	old, ok := points[len(points)-(1+hours)].Assets[asset.Key]
+	if !ok {
+		return 0
+	}
+	usd := 0.0
+	for i := len(points) - hours; i < len(points); i++ {
+		s, ok := points[i].Assets[asset.Key]
+		if !ok {
+			continue
+		}
+		now := netted(s, s.Internal, asset.Stablecoin)
+		from := netted(old, nil, asset.Stablecoin)
+		_, sum := analyze(now, from, asset.Key, asset.Stablecoin)
+		usd += sum * seriesPrice(s, asset)
+		old = s
+	}
+	return usd
+}
+
+// overallChange is the usd change of every asset over the last hours.
+// The summary and the website both show it
+func overallChange(points []Point, assets []Asset, hours int) float64 {
+	usd := 0.0
+	for _, asset := range assets {
+		usd += usdChange(points, asset, hours)
+	}
+	return usd
+}
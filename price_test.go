@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHourlyPrices(t *testing.T) {
+	raw := [][2]float64{
+		{7300000, 2010},
+		{3600000, 2000},
+		{3700000, 2005},
+		{10800000, 0},
+	}
+	got := hourlyPrices(raw)
+	want := []pricePoint{{Date: 3600, USD: 2005}, {Date: 7200, USD: 2010}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPriceAt(t *testing.T) {
+	prices := []pricePoint{{Date: 3600, USD: 2000}, {Date: 10800, USD: 2100}}
+	for date, want := range map[int64]float64{0: 0, 3600: 2000, 7205: 2000, 10800: 2100, 99999: 2100} {
+		if got := priceAt(prices, date); got != want {
+			t.Errorf("%d: got %v, want %v", date, got, want)
+		}
+	}
+}
+
+func TestUSDChange(t *testing.T) {
+	eth := Asset{Key: "ETH", Price: 3000}
+	points := []Point{
+		{Date: 1, Assets: map[string]Series{"ETH": {Exchange: 100, DiamondHands: 100, Price: 1000}}},
+		// 10 left exchanges for cold wallets at 1000
+		{Date: 2, Assets: map[string]Series{"ETH": {Exchange: 90, DiamondHands: 110, Price: 1000}}},
+		{Date: 3},
+		// 5 moved from a tracked whale into an exchange so 5 others left exchanges at 2000
+		{Date: 4, Assets: map[string]Series{"ETH": {Exchange: 90, DiamondHands: 110, Internal: map[string]float64{"diamond_hands": 5}, Price: 2000}}},
+		// no price so today's
+		{Date: 5, Assets: map[string]Series{"ETH": {Exchange: 89, DiamondHands: 111}}},
+	}
+	tests := map[int]float64{
+		1: 2 * 3000,
+		3: 10*2000 + 2*3000,
+		4: 20*1000 + 10*2000 + 2*3000,
+		9: 0,
+	}
+	for hours, want := range tests {
+		if got := usdChange(points, eth, hours); math.Abs(got-want) > 1e-6 {
+			t.Errorf("%dh: got %v, want %v", hours, got, want)
+		}
+	}
+	// missing at the start
+	if got := usdChange(points, eth, 2); got != 0 {
+		t.Errorf("2h: got %v, want 0", got)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+type RPCConfig struct {
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// TimeoutSeconds overrides the http timeout for slow calls
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// rpcClient calls a JSON-RPC endpoint over http
+type rpcClient struct {
+	config  RPCConfig
+	fetcher *Fetcher
+	// version is the jsonrpc field. bitcoind before v28 only speaks 1.0
+	version string
+	id      int64
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func newRPCClient(config RPCConfig, f *Fetcher, version string) *rpcClient {
+	// failed calls are answered with a server error that retrying won't fix
+	rpc := *f
+	rpc.JSONErrors = true
+	return &rpcClient{config: config, fetcher: &rpc, version: version}
+}
+
+func (c *rpcClient) post(ctx context.Context, method string, body []byte) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if c.config.User != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(c.config.User + ":" + c.config.Password))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	content, err := c.fetcher.Do(ctx, http.MethodPost, c.config.URL, header, body)
+	var serr StatusError
+	if errors.As(err, &serr) && len(serr.Body) > 0 {
+		// bitcoind responds to failed calls with a status code error and the reason in the body
+		return serr.Body, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s error: %w", method, err)
+	}
+	return content, nil
+}
+
+func (c *rpcClient) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{c.version, atomic.AddInt64(&c.id, 1), method, params})
+	if err != nil {
+		return err
+	}
+	content, err := c.post(ctx, method, body)
+	if err != nil {
+		return err
+	}
+	var res rpcResponse
+	err = json.Unmarshal(content, &res)
+	if err != nil {
+		return fmt.Errorf("%s response error: %w", method, err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("%s error: %w", method, res.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// batch sends the same method with many params in one request.
+// results is filled in the order of params
+func (c *rpcClient) batch(ctx context.Context, method string, params []interface{}, results []json.RawMessage) error {
+	if len(params) == 0 {
+		return nil
+	}
+	first := atomic.AddInt64(&c.id, int64(len(params))) - int64(len(params)) + 1
+	var reqs []rpcRequest
+	for i, p := range params {
+		reqs = append(reqs, rpcRequest{c.version, first + int64(i), method, p})
+	}
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+	content, err := c.post(ctx, method, body)
+	if err != nil {
+		return err
+	}
+	var responses []rpcResponse
+	err = json.Unmarshal(content, &responses)
+	if err != nil {
+		return fmt.Errorf("%s response error: %w", method, err)
+	}
+	if len(responses) != len(params) {
+		return fmt.Errorf("%s: got %d responses for %d calls", method, len(responses), len(params))
+	}
+	// responses can come in any order
+	for _, res := range responses {
+		i := res.ID - first
+		if i < 0 || i >= int64(len(params)) {
+			return fmt.Errorf("%s: unexpected response id %d", method, res.ID)
+		}
+		if res.Error != nil {
+			return fmt.Errorf("%s error: %w", method, res.Error)
+		}
+		results[i] = res.Result
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	runRunning   = "running"
+	runSuccess   = "success"
+	runFailed    = "failed"
+	runDiscarded = "discarded"
+)
+
+// Run is a single scrape of a source. Every balance belongs to one
+type Run struct {
+	ID      int
+	Source  string
+	Chain   string
+	Symbol  string
+	BatchAt time.Time
+}
+
+func beginRun(ctx context.Context, conn *pgxpool.Pool, s Source, batchAt time.Time) (Run, error) {
+	run := Run{Source: s.Source(), Chain: s.Chain(), Symbol: s.Symbol(), BatchAt: batchAt}
+	query := `
+		INSERT INTO scrape_run
+		(source, blockchain, symbol, batch_at, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING run_id;
+	`
+	err := conn.QueryRow(ctx, query, run.Source, run.Chain, run.Symbol, run.BatchAt, runRunning).Scan(&run.ID)
+	if err != nil {
+		return run, fmt.Errorf("begin run error: %w", err)
+	}
+	return run, nil
+}
+
+// finishRun records the outcome of a run. Queue it in the same batch as the balances of successful runs
+func finishRun(batch *pgx.Batch, run Run, status string, pages, rows int, archiveID string) {
+	query := `
+		UPDATE scrape_run
+		SET finished_at = NOW(), status = $2, page_count = $3, row_count = $4, archive_id = NULLIF($5, '')
+		WHERE run_id = $1;
+	`
+	batch.Queue(query, run.ID, status, pages, rows, archiveID)
+}
+
+// failRun records a run that saved no balances
+func failRun(ctx context.Context, conn *pgxpool.Pool, run Run, status string, pages, rows int, archiveID string) error {
+	batch := &pgx.Batch{}
+	finishRun(batch, run, status, pages, rows, archiveID)
+	err := conn.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("finish run error: %w", err)
+	}
+	return nil
+}
+
+// abandonRun records a run that saved no balances after an error. Its own error is only logged
+// so the error that stopped the run is the one returned
+func abandonRun(ctx context.Context, conn *pgxpool.Pool, run Run, status string, pages, rows int, archiveID string) {
+	err := failRun(ctx, conn, run, status, pages, rows, archiveID)
+	if err != nil {
+		fmt.Printf("run %d: %v\n", run.ID, err)
+	}
+}
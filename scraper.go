@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/sync/errgroup"
+)
+
+// Scraper describes a paginated rich list that can be parsed into wallets
+type Scraper interface {
+	Source
+	// Pages is the number of pages to scrape
+	Pages() int
+	// PageURL returns the url of a page. Pages start at 1
+	PageURL(page int) string
+	// Schema is what a page is expected to look like.
+	// Pages that don't match are retried
+	Schema() PageSchema
+	// Rows selects the table rows that contain wallets
+	Rows(doc *goquery.Document) *goquery.Selection
+	// ParseRow extracts a wallet from a row. Errors if the balance is unreadable.
+	// Blockchain, Symbol and OwnerType are filled in by the caller
+	ParseRow(row *goquery.Selection) (Wallet, error)
+}
+
+var (
+	// native rich lists keyed by site
+	richLists = map[string]func(Blockchain) Scraper{}
+	// token holder lists keyed by site
+	tokenScrapers = map[string]func(Blockchain, TokenContract) Scraper{}
+)
+
+func registerRichList(site string, newScraper func(Blockchain) Scraper) {
+	richLists[site] = newScraper
+}
+
+func registerTokenScraper(site string, newScraper func(Blockchain, TokenContract) Scraper) {
+	tokenScrapers[site] = newScraper
+}
+
+func init() {
+	registerRichList("bitinfocharts", func(chain Blockchain) Scraper {
+		return bitinfochartsScraper{chain: chain}
+	})
+	registerRichList("etherscan", func(chain Blockchain) Scraper {
+		return etherscanScraper{chain: chain}
+	})
+	registerTokenScraper("etherscan", func(chain Blockchain, token TokenContract) Scraper {
+		return etherscanTokenScraper{chain: chain, token: token}
+	})
+}
+
+// scrapersFor lists the native scraper of a blockchain followed by its token scrapers
+func scrapersFor(blockchain Blockchain, tokens []TokenContract) []Scraper {
+	var ss []Scraper
+	if newScraper, ok := richLists[blockchain.RichList]; ok {
+		ss = append(ss, newScraper(blockchain))
+	}
+	newTokenScraper, ok := tokenScrapers[blockchain.RichList]
+	if !ok {
+		return ss
+	}
+	for _, token := range tokens {
+		if token.Blockchain == blockchain.Name {
+			ss = append(ss, newTokenScraper(blockchain, token))
+		}
+	}
+	return ss
+}
+
+// updater saves the balances of scrapers
+type updater struct {
+	pool    *pgxpool.Pool
+	fetcher *Fetcher
+	archive *Archive
+	// batchAt is when the batch update began
+	batchAt time.Time
+	// supplies are the total supplies of tokens keyed by symbol
+	supplies   map[string]float64
+	classifier Classifier
+}
+
+func (u updater) update(ctx context.Context, s Scraper) error {
+	run, err := beginRun(ctx, u.pool, s, u.batchAt)
+	if err != nil {
+		return err
+	}
+	wallets, report, err := scrape(ctx, u.fetcher, u.archive, s)
+	_, _, count := report.totals()
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, "")
+		return fmt.Errorf("%s %s scrape error: %w", s.Source(), s.Symbol(), err)
+	}
+	archived := newArchivedRun(s, run.ID, report)
+	err = u.archive.SaveRun(archived)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, "")
+		return fmt.Errorf("archive error: %w", err)
+	}
+	archiveID := ""
+	if u.archive != nil {
+		archiveID = archived.ID
+	}
+	err = logHealth(ctx, u.pool, run.ID, report)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, archiveID)
+		return err
+	}
+	if !report.Healthy() {
+		// a partial snapshot would look like whales emptying their wallets
+		abandonRun(ctx, u.pool, run, runDiscarded, len(report.Pages), count, archiveID)
+		return fmt.Errorf("%s %s layout may have changed. discarding %d wallets of run %d:\n%s", s.Source(), s.Symbol(), len(wallets), run.ID, strings.Join(report.Problems(), "\n"))
+	}
+	classifier, err := u.classifier.labelled(ctx, u.pool, wallets)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, archiveID)
+		return err
+	}
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, archiveID)
+		return err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	logScrape(batch, withPercentages(classifier.classifyAll(wallets), u.supplies[s.Symbol()]), run.ID, archived.CapturedAt)
+	logMembership(batch, run)
+	finishRun(batch, run, runSuccess, len(report.Pages), count, archiveID)
+	err = commit(ctx, tx, batch)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, len(report.Pages), count, archiveID)
+		return err
+	}
+	changes, err := membershipChanges(ctx, u.pool, run)
+	if err != nil {
+		// the run is already saved
+		fmt.Println(err)
+	} else if changes.String() != "" {
+		fmt.Println(changes)
+	}
+	return nil
+}
+
+// scrape fetches pages concurrently then merges them in page order
+func scrape(ctx context.Context, f *Fetcher, archive *Archive, s Scraper) ([]Wallet, HealthReport, error) {
+	report := HealthReport{Source: s.Source(), Chain: s.Chain(), Symbol: s.Symbol()}
+	pages := make([][]Wallet, s.Pages())
+	report.Pages = make([]PageHealth, s.Pages())
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	queue := make(chan int)
+	eg.Go(func() error {
+		defer close(queue)
+		for i := range pages {
+			select {
+			case queue <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	for w := 0; w < workers; w++ {
+		eg.Go(func() error {
+			for i := range queue {
+				ws, health, err := scrapePage(ctx, f, archive, s, i+1, f.Retries)
+				if err != nil {
+					return err
+				}
+				pages[i] = ws
+				report.Pages[i] = health
+			}
+			return nil
+		})
+	}
+	err := eg.Wait()
+	if err != nil {
+		return nil, report, err
+	}
+	return merge(pages), report, nil
+}
+
+// merge joins pages in order. Wallets that moved between pages while scraping are only kept once
+func merge(pages [][]Wallet) []Wallet {
+	var wallets []Wallet
+	for _, ws := range pages {
+		for i, wallet := range wallets {
+			for _, w := range ws {
+				if wallet.Address == w.Address {
+					// remove eariler duplicate
+					wallets[i] = Wallet{}
+				}
+			}
+		}
+		wallets = append(wallets, ws...)
+	}
+	return wallets
+}
+
+func scrapePage(ctx context.Context, f *Fetcher, archive *Archive, s Scraper, page, retries int) ([]Wallet, PageHealth, error) {
+	pageURL := s.PageURL(page)
+	content, err := f.Get(ctx, pageURL)
+	if err != nil {
+		return nil, PageHealth{Page: page, URL: pageURL}, err
+	}
+	wallets, health, err := parsePage(s, page, pageURL, content)
+	if err != nil {
+		return nil, health, err
+	}
+	if health.Problem != "" && retries > 0 {
+		// sites sometimes serve incomplete pages
+		err = sleep(ctx, f.backoff(f.Retries-retries))
+		if err != nil {
+			return nil, health, err
+		}
+		return scrapePage(ctx, f, archive, s, page, retries-1)
+	}
+	health.Hash, err = archive.Put(content)
+	if err != nil {
+		return nil, health, fmt.Errorf("archive error: %w", err)
+	}
+	fmt.Println(pageURL)
+	return wallets, health, nil
+}
+
+func parsePage(s Scraper, page int, pageURL string, content []byte) ([]Wallet, PageHealth, error) {
+	health := PageHealth{Page: page, URL: pageURL}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, health, err
+	}
+	var wallets []Wallet
+	s.Rows(doc).Each(func(i int, row *goquery.Selection) {
+		if row.Find("td").Length() == 0 {
+			// header
+			return
+		}
+		health.Rows++
+		wallet, err := s.ParseRow(row)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		health.Parsed++
+		if wallet.Balance <= 0 || wallet.Address == "" {
+			return
+		}
+		wallet.Blockchain = s.Chain()
+		wallet.Symbol = s.Symbol()
+		wallet.OwnerType = ownerType(wallet)
+		wallets = append(wallets, wallet)
+	})
+	health.Wallets = len(wallets)
+	health.check(s.Schema(), headers(doc))
+	return wallets, health, nil
+}
+
+// ownerType guesses the owner type of wallets no label or rule matched.
+// Inaccurate guesses can be fixed in whale_override
+func ownerType(wallet Wallet) string {
+	if wallet.IsContract {
+		return "contract"
+	}
+	if wallet.Name != "" {
+		return "exchange"
+	}
+	return "unknown"
+}
+
+// parseBalance reads a comma separated amount that may be followed by a unit
+func parseBalance(text, unit string) (float64, error) {
+	if unit != "" {
+		text = strings.Split(text, unit)[0]
+	}
+	text = strings.ReplaceAll(text, ",", "")
+	return strconv.ParseFloat(text, 64)
+}
+
+// baseURL returns base unless it is empty
+func baseURL(base, fallback string) string {
+	if base == "" {
+		return fallback
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// bitinfochartsScraper reads the rich list of a utxo chain like bitcoin, litecoin or dogecoin
+type bitinfochartsScraper struct {
+	BaseURL string
+	chain   Blockchain
+}
+
+func (bitinfochartsScraper) Source() string   { return "bitinfocharts" }
+func (s bitinfochartsScraper) Chain() string  { return s.chain.Name }
+func (s bitinfochartsScraper) Symbol() string { return s.chain.Symbol }
+func (bitinfochartsScraper) Pages() int       { return 40 }
+
+func (bitinfochartsScraper) Schema() PageSchema {
+	return PageSchema{Headers: []string{"address", "balance"}, MinRows: 100, MinParseRate: 0.95}
+}
+
+func (s bitinfochartsScraper) PageURL(page int) string {
+	// bitcoin-cash is listed as "bitcoin cash"
+	slug := url.PathEscape(strings.ReplaceAll(s.chain.Name, "-", " "))
+	return fmt.Sprintf("%s/top-100-richest-%s-addresses-%d.html", baseURL(s.BaseURL, "https://bitinfocharts.com"), slug, page)
+}
+
+func (bitinfochartsScraper) Rows(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("#tblOne, #tblOne2").Find("tr")
+}
+
+func (s bitinfochartsScraper) ParseRow(row *goquery.Selection) (Wallet, error) {
+	var wallet Wallet
+	var err error
+	row.Find("td").Each(func(j int, ss *goquery.Selection) {
+		text := strings.ReplaceAll(ss.Text(), " ", "")
+		switch j {
+		case 1:
+			//Wallet
+			ss.Find("a").Each(func(k int, sss *goquery.Selection) {
+				t := strings.ReplaceAll(sss.Text(), " ", "")
+				switch k {
+				case 0:
+					wallet.Address = t
+				case 1:
+					wallet.Name = t
+				}
+			})
+		case 2:
+			//balance
+			wallet.Balance, err = parseBalance(text, s.Symbol())
+		}
+	})
+	return wallet, err
+}
+
+// etherscanExplorer is the default explorer of chains with an etherscan rich list
+const etherscanExplorer = "https://etherscan.io"
+
+// explorerName names an explorer by its domain. eg. bscscan for https://bscscan.com
+func explorerName(base string) string {
+	u, err := url.Parse(base)
+	if err != nil || u.Hostname() == "" {
+		return "etherscan"
+	}
+	return strings.Split(strings.TrimPrefix(u.Hostname(), "www."), ".")[0]
+}
+
+// etherscanScraper reads the rich list of ethereum or of an evm chain whose explorer shares etherscan's layout
+type etherscanScraper struct {
+	BaseURL string
+	chain   Blockchain
+}
+
+func (s etherscanScraper) Source() string { return explorerName(s.chain.explorer()) }
+func (s etherscanScraper) Chain() string  { return s.chain.Name }
+func (s etherscanScraper) Symbol() string { return s.chain.Symbol }
+func (etherscanScraper) Pages() int       { return 100 }
+
+func (etherscanScraper) Schema() PageSchema {
+	return PageSchema{Headers: []string{"address", "name tag", "balance"}, MinRows: 100, MinParseRate: 0.95}
+}
+
+func (s etherscanScraper) PageURL(page int) string {
+	return fmt.Sprintf("%s/accounts/%d?ps=100", baseURL(s.BaseURL, s.chain.explorer()), page)
+}
+
+func (etherscanScraper) Rows(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("tr")
+}
+
+func (s etherscanScraper) ParseRow(row *goquery.Selection) (Wallet, error) {
+	// other explorers label balances with the symbol
+	unit := s.chain.Symbol
+	if s.Source() == "etherscan" {
+		unit = "Ether"
+	}
+	var wallet Wallet
+	var err error
+	row.Find("td").Each(func(j int, ss *goquery.Selection) {
+		text := strings.ReplaceAll(ss.Text(), " ", "")
+		switch j {
+		case 1:
+			html, _ := ss.Html()
+			wallet.IsContract = strings.Contains(html, "Contract")
+			wallet.Address = text
+		case 2:
+			wallet.Name = text
+		case 3:
+			wallet.Balance, err = parseBalance(text, unit)
+			// case 4:
+			// percentage := strings.ReplaceAll(text, "%", "")
+			// f, err := strconv.ParseFloat(percentage, 64)
+			// if err != nil {
+			// 	log.Fatal(err)
+			// }
+			// wallet.Percentage = f
+		}
+	})
+	return wallet, err
+}
+
+type etherscanTokenScraper struct {
+	BaseURL string
+	chain   Blockchain
+	token   TokenContract
+}
+
+func (s etherscanTokenScraper) Source() string { return explorerName(s.chain.explorer()) }
+func (s etherscanTokenScraper) Chain() string  { return s.token.Blockchain }
+func (s etherscanTokenScraper) Symbol() string { return s.token.Symbol }
+func (etherscanTokenScraper) Pages() int       { return 20 }
+
+func (etherscanTokenScraper) Schema() PageSchema {
+	return PageSchema{Headers: []string{"address", "quantity"}, MinRows: 50, MinParseRate: 0.95}
+}
+
+func (s etherscanTokenScraper) PageURL(page int) string {
+	return fmt.Sprintf("%s/token/generic-tokenholders2?a=%s&p=%d", baseURL(s.BaseURL, s.chain.explorer()), s.token.Address, page)
+}
+
+func (etherscanTokenScraper) Rows(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("tr")
+}
+
+func (s etherscanTokenScraper) ParseRow(row *goquery.Selection) (Wallet, error) {
+	var wallet Wallet
+	var err error
+	row.Find("td").Each(func(j int, ss *goquery.Selection) {
+		text := strings.ReplaceAll(ss.Text(), " ", "")
+		switch j {
+		case 1:
+			html, _ := ss.Html()
+			wallet.IsContract = strings.Contains(html, "Contract")
+			if strings.HasPrefix(text, "0x") {
+				wallet.Address = text
+			} else {
+				// named holders only show their address in the link
+				wallet.Name = text
+				wallet.Address = linkedAddress(ss)
+			}
+		case 2:
+			wallet.Balance, err = parseBalance(text, "")
+		}
+	})
+	return wallet, err
+}
+
+// linkedAddress finds the holder address in a link like
+// /token/<contract>?a=<holder> or /address/<holder>
+func linkedAddress(s *goquery.Selection) string {
+	var address string
+	s.Find("a").EachWithBreak(func(i int, a *goquery.Selection) bool {
+		href, ok := a.Attr("href")
+		if !ok {
+			return true
+		}
+		u, err := url.Parse(href)
+		if err != nil {
+			return true
+		}
+		if a := u.Query().Get("a"); a != "" {
+			address = a
+		} else if strings.HasPrefix(u.Path, "/address/") {
+			address = strings.TrimPrefix(u.Path, "/address/")
+		}
+		return address == ""
+	})
+	return address
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFetcher fetches from server without waiting or retrying
+func testFetcher(server *httptest.Server) *Fetcher {
+	return &Fetcher{Client: server.Client()}
+}
+
+// serveFixture serves the same saved page for every request
+func serveFixture(t *testing.T, path string) *httptest.Server {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func assertWallets(t *testing.T, got, want []Wallet) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d wallets, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wallet %d:\n got %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestScrapeBTC(t *testing.T) {
+	server := serveFixture(t, "testdata/bitinfocharts.html")
+	wallets, _, err := scrapePage(context.Background(), testFetcher(server), nil, bitinfochartsScraper{BaseURL: server.URL, chain: bitcoin}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "34xp4vRoCGJym3xR7yCVPFHoCNxv4Twseo", Name: "Binance-coldwallet", Balance: 248597, OwnerType: "exchange"},
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "bc1qgdjqv0av3q56jvd82tkdjpy7gdp9ut8tlqmgrpmv24sq90ecnvqqjwvw97", Balance: 168010.00001, OwnerType: "unknown"},
+		{Blockchain: "bitcoin", Symbol: "BTC", Address: "1P5ZEDWTKTFGxQjZphgWPQUpe554WKDfHQ", Name: "wallet:38727413", Balance: 126330, OwnerType: "exchange"},
+	})
+}
+
+func TestScrapeUTXOChains(t *testing.T) {
+	dogecoin := Blockchain{Name: "dogecoin", Symbol: "DOGE", RichList: "bitinfocharts"}
+	server := serveFixture(t, "testdata/bitinfocharts_dogecoin.html")
+	wallets, _, err := scrapePage(context.Background(), testFetcher(server), nil, bitinfochartsScraper{BaseURL: server.URL, chain: dogecoin}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "dogecoin", Symbol: "DOGE", Address: "DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L", Name: "Robinhood", Balance: 36700000000.5, OwnerType: "exchange"},
+		{Blockchain: "dogecoin", Symbol: "DOGE", Address: "DDuXGMFLoxGFDaNDtLcjpbBm1vRaKT5v8W", Balance: 2430000000, OwnerType: "unknown"},
+	})
+
+	bch := Blockchain{Name: "bitcoin-cash", Symbol: "BCH", RichList: "bitinfocharts"}
+	ss := scrapersFor(bch, []TokenContract{{Symbol: "USDT", Blockchain: "ethereum"}})
+	if len(ss) != 1 {
+		t.Fatalf("got %d scrapers, want 1", len(ss))
+	}
+	if got, want := ss[0].PageURL(2), "https://bitinfocharts.com/top-100-richest-bitcoin%20cash-addresses-2.html"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if ss[0].Chain() != "bitcoin-cash" || ss[0].Symbol() != "BCH" {
+		t.Errorf("got %s %s, want bitcoin-cash BCH", ss[0].Chain(), ss[0].Symbol())
+	}
+}
+
+func TestScrapeEth(t *testing.T) {
+	server := serveFixture(t, "testdata/etherscan_accounts.html")
+	wallets, _, err := scrapePage(context.Background(), testFetcher(server), nil, etherscanScraper{BaseURL: server.URL, chain: ethereum}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0x00000000219ab540356cbb839cbe05303d7705fa", Name: "Eth2DepositContract", Balance: 8898450.00069, IsContract: true, OwnerType: "contract"},
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0xbe0eb53f46cd790cd13851d5eff43d12404d33e8", Name: "Binance7", Balance: 1996008.379, OwnerType: "exchange"},
+		{Blockchain: "ethereum", Symbol: "ETH", Address: "0x73bceb1cd57c711feac4224d062b0f6ff338501e", Balance: 1923504.64, OwnerType: "unknown"},
+	})
+}
+
+func TestScrapeEVMChains(t *testing.T) {
+	bsc := Blockchain{Name: "bsc", Symbol: "BNB", RichList: "etherscan", Explorer: "https://bscscan.com/"}
+	server := serveFixture(t, "testdata/bscscan_accounts.html")
+	wallets, _, err := scrapePage(context.Background(), testFetcher(server), nil, etherscanScraper{BaseURL: server.URL, chain: bsc}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "bsc", Symbol: "BNB", Address: "0x0000000000000000000000000000000000001004", Name: "BSC:TokenHub", Balance: 29842181.62, IsContract: true, OwnerType: "contract"},
+		{Blockchain: "bsc", Symbol: "BNB", Address: "0xf977814e90da44bfa03b6295a0616a897441acec", Name: "Binance8", Balance: 5000011.5, OwnerType: "exchange"},
+	})
+
+	tokens := []TokenContract{
+		{Symbol: "BUSD", Address: "0xe9e7cea3dedca5984780bafc599bd69add087d56", Blockchain: "bsc"},
+		{Symbol: "USDT", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Blockchain: "ethereum"},
+	}
+	ss := scrapersFor(bsc, tokens)
+	if len(ss) != 2 {
+		t.Fatalf("got %d scrapers, want 2", len(ss))
+	}
+	for i, want := range []struct{ source, chain, symbol, url string }{
+		{"bscscan", "bsc", "BNB", "https://bscscan.com/accounts/3?ps=100"},
+		{"bscscan", "bsc", "BUSD", "https://bscscan.com/token/generic-tokenholders2?a=0xe9e7cea3dedca5984780bafc599bd69add087d56&p=3"},
+	} {
+		s := ss[i]
+		if s.Source() != want.source || s.Chain() != want.chain || s.Symbol() != want.symbol || s.PageURL(3) != want.url {
+			t.Errorf("scraper %d: got %s %s %s %s, want %+v", i, s.Source(), s.Chain(), s.Symbol(), s.PageURL(3), want)
+		}
+	}
+	if got := scrapersFor(ethereum, tokens)[0].Source(); got != "etherscan" {
+		t.Errorf("got %s, want etherscan", got)
+	}
+}
+
+func TestScrapeEthToken(t *testing.T) {
+	server := serveFixture(t, "testdata/etherscan_tokenholders.html")
+	token := TokenContract{Symbol: "USDT", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Blockchain: "ethereum"}
+	wallets, _, err := scrapePage(context.Background(), testFetcher(server), nil, etherscanTokenScraper{BaseURL: server.URL, chain: ethereum, token: token}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWallets(t, wallets, []Wallet{
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0x5754284f345afc66a98fbb0a0afe71e0f007b949", Name: "Tether:Treasury", Balance: 1014470262.55, OwnerType: "exchange"},
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0x47ac0fb4f2d84898e4d9e7b4dab3c24507a6d503", Balance: 960000000, IsContract: true, OwnerType: "contract"},
+		// named holder without a ?a= link
+		{Blockchain: "ethereum", Symbol: "USDT", Address: "0xf977814e90da44bfa03b6295a0616a897441acec", Name: "Binance8", Balance: 600100000.5, OwnerType: "exchange"},
+	})
+}
+
+func TestScrapeDedupe(t *testing.T) {
+	server := serveFixture(t, "testdata/etherscan_accounts.html")
+	s := etherscanScraper{BaseURL: server.URL, chain: ethereum}
+	wallets, _, err := scrape(context.Background(), testFetcher(server), nil, pagedScraper{s, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for _, w := range wallets {
+		if w.Balance > 0 {
+			count++
+		}
+	}
+	if count != 3 {
+		t.Errorf("got %d unique wallets, want 3", count)
+	}
+}
+
+func TestScrapeConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	content, err := ioutil.ReadFile("testdata/etherscan_accounts.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	f := testFetcher(server)
+	f.Workers = 3
+	_, report, err := scrape(context.Background(), f, nil, pagedScraper{etherscanScraper{BaseURL: server.URL, chain: ethereum}, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range report.Pages {
+		if p.Page != i+1 {
+			t.Errorf("page %d reported as %d", i+1, p.Page)
+		}
+	}
+	if peak < 2 || peak > 3 {
+		t.Errorf("got %d concurrent requests, want between 2 and 3", peak)
+	}
+}
+
+// pagedScraper limits the number of pages of a scraper
+type pagedScraper struct {
+	Scraper
+	pages int
+}
+
+func (s pagedScraper) Pages() int { return s.pages }
+
+// schemaScraper replaces the schema of a scraper
+type schemaScraper struct {
+	Scraper
+	schema PageSchema
+}
+
+func (s schemaScraper) Schema() PageSchema { return s.schema }
+
+func TestPageHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		schema  PageSchema
+		health  PageHealth
+	}{
+		{
+			name:    "matches",
+			fixture: "testdata/etherscan_accounts.html",
+			schema:  PageSchema{Headers: []string{"Address", "Name Tag", "Balance"}, MinRows: 3, MinParseRate: 0.95},
+			health:  PageHealth{Rows: 3, Parsed: 3, Wallets: 3},
+		},
+		{
+			name:    "too few wallets",
+			fixture: "testdata/etherscan_accounts.html",
+			schema:  PageSchema{Headers: []string{"Address"}, MinRows: 100},
+			health:  PageHealth{Rows: 3, Parsed: 3, Wallets: 3, Problem: "found 3 wallets, expected at least 100"},
+		},
+		{
+			name:    "renamed headers",
+			fixture: "testdata/etherscan_accounts_drift.html",
+			schema:  etherscanScraper{chain: ethereum}.Schema(),
+			health:  PageHealth{Rows: 3, Parsed: 1, Wallets: 1, MissingHeaders: []string{"address", "name tag"}, Problem: "missing headers address, name tag"},
+		},
+		{
+			name:    "unreadable balances",
+			fixture: "testdata/etherscan_accounts_drift.html",
+			schema:  PageSchema{Headers: []string{"Balance"}, MinRows: 1, MinParseRate: 0.95},
+			health:  PageHealth{Rows: 3, Parsed: 1, Wallets: 1, Problem: "parsed 1 of 3 balances, expected at least 95%"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := serveFixture(t, tt.fixture)
+			s := schemaScraper{etherscanScraper{BaseURL: server.URL, chain: ethereum}, tt.schema}
+			_, health, err := scrapePage(context.Background(), testFetcher(server), nil, s, 1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			health.Page, health.URL = 0, ""
+			if fmt.Sprint(health) != fmt.Sprint(tt.health) {
+				t.Errorf("got %+v, want %+v", health, tt.health)
+			}
+		})
+	}
+}
+
+func TestUnhealthyReport(t *testing.T) {
+	server := serveFixture(t, "testdata/etherscan_accounts_drift.html")
+	_, report, err := scrape(context.Background(), testFetcher(server), nil, pagedScraper{etherscanScraper{BaseURL: server.URL, chain: ethereum}, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() {
+		t.Fatal("expected drifted layout to be unhealthy")
+	}
+	if got := len(report.Problems()); got != 2 {
+		t.Errorf("got %d problems, want 2", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Source identifies where balances come from
+type Source interface {
+	// Source is the name of the site or node balances are read from
+	Source() string
+	// Chain is the blockchain the wallets belong to
+	Chain() string
+	// Symbol is the asset whose balance is read
+	Symbol() string
+}
+
+// BalanceSource reads the balances of whales that are already known instead of discovering them from a rich list
+type BalanceSource interface {
+	Source
+	Balances(ctx context.Context, whales []Wallet) ([]Wallet, error)
+}
+
+// knownWhales lists the whales that have held the asset of a source
+func (u updater) knownWhales(ctx context.Context, src Source) ([]Wallet, error) {
+	query := `
+		SELECT w.address, coalesce(w.owner, ''), w.owner_type, w.is_contract
+		FROM whale w
+		WHERE w.blockchain = $1
+		AND EXISTS (SELECT 1 FROM balance b WHERE b.whale_id = w.whale_id AND b.symbol = $2)
+		ORDER BY w.whale_id;
+	`
+	blockchain := src.Chain()
+	rows, err := u.pool.Query(ctx, query, blockchain, src.Symbol())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+	var whales []Wallet
+	for rows.Next() {
+		wallet := Wallet{Blockchain: blockchain}
+		err := rows.Scan(&wallet.Address, &wallet.Name, &wallet.OwnerType, &wallet.IsContract)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		whales = append(whales, wallet)
+	}
+	return whales, rows.Err()
+}
+
+// refresh saves the latest balances of known whales from a balance source
+func (u updater) refresh(ctx context.Context, src BalanceSource) error {
+	whales, err := u.knownWhales(ctx, src)
+	if err != nil {
+		return err
+	}
+	run, err := beginRun(ctx, u.pool, src, u.batchAt)
+	if err != nil {
+		return err
+	}
+	wallets, err := src.Balances(ctx, whales)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, 0, 0, "")
+		return fmt.Errorf("%s %s balance error: %w", src.Source(), src.Symbol(), err)
+	}
+	classifier, err := u.classifier.labelled(ctx, u.pool, wallets)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, 0, len(wallets), "")
+		return err
+	}
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, 0, len(wallets), "")
+		return err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	logScrape(batch, withPercentages(classifier.classifyAll(wallets), u.supplies[src.Symbol()]), run.ID, u.batchAt)
+	finishRun(batch, run, runSuccess, 0, len(wallets), "")
+	err = commit(ctx, tx, batch)
+	if err != nil {
+		abandonRun(ctx, u.pool, run, runFailed, 0, len(wallets), "")
+	}
+	return err
+}
+
+const (
+	// modeScrape discovers whales and their balances from rich lists. The default
+	modeScrape = "scrape"
+	// modeRPC reads balances of known whales from a node
+	modeRPC = "rpc"
+	// modeAPI reads balances of known whales from an etherscan style api
+	modeAPI = "api"
+)
+
+// balanceSources lists the sources of a blockchain that is not scraped
+func balanceSources(config Config, f *Fetcher, blockchain Blockchain) ([]BalanceSource, error) {
+	mode := config.Sources[blockchain.Name]
+	switch {
+	case mode == "" || mode == modeScrape:
+		return nil, nil
+	case mode == modeRPC && blockchain.Name == bitcoin.Name:
+		if config.BitcoinRPC.URL == "" {
+			return nil, errors.New("bitcoin_rpc url is required to read bitcoin balances from a node")
+		}
+		return []BalanceSource{newBitcoindSource(config.BitcoinRPC, f)}, nil
+	case mode == modeRPC && blockchain.RichList == ethereum.RichList:
+		rpc, ok := config.chainRPC(blockchain)
+		if !ok {
+			return nil, fmt.Errorf("%s rpc url is required to read its balances from a node. set rpc of the chain or ethereum_rpc for ethereum", blockchain.Name)
+		}
+		// one node so every balance is read at the same block
+		node := newEthNode(rpc, f)
+		sources := []BalanceSource{ethSource{node, blockchain.Name, blockchain.Symbol}}
+		for _, token := range config.Tokens {
+			if token.Blockchain == blockchain.Name {
+				sources = append(sources, erc20Source{node, token})
+			}
+		}
+		return sources, nil
+	case mode == modeAPI && blockchain.RichList == ethereum.RichList:
+		api, ok := config.chainAPI(blockchain)
+		if !ok {
+			return nil, fmt.Errorf("%s api url and key are required to read its balances from the api. set api of the chain or etherscan_api for ethereum", blockchain.Name)
+		}
+		client := newEtherscanAPI(api, f)
+		sources := []BalanceSource{etherscanAPISource{client, blockchain.Name, blockchain.Symbol}}
+		for _, token := range config.Tokens {
+			if token.Blockchain == blockchain.Name {
+				sources = append(sources, etherscanTokenAPISource{client, token})
+			}
+		}
+		return sources, nil
+	}
+	return nil, fmt.Errorf("%s source %q is not supported", blockchain.Name, mode)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// contractCaller calls read only functions of contracts
+type contractCaller interface {
+	ethCall(ctx context.Context, to, data string) (string, error)
+}
+
+// TokenInfo is what the token contract says about itself
+type TokenInfo struct {
+	Name     string
+	Decimals int
+	// TotalSupply in whole tokens
+	TotalSupply float64
+}
+
+// contractCallerFor picks how the contracts of a blockchain are read. nil if there is no way.
+// The node or api that reads its balances is reused so supplies are read at the same block
+func contractCallerFor(config Config, f *Fetcher, blockchain Blockchain, sources []BalanceSource) contractCaller {
+	for _, src := range sources {
+		switch s := src.(type) {
+		case ethSource:
+			return s.node
+		case erc20Source:
+			return s.node
+		case etherscanAPISource:
+			return s.api
+		case etherscanTokenAPISource:
+			return s.api
+		}
+	}
+	if rpc, ok := config.chainRPC(blockchain); ok {
+		return newEthNode(rpc, f)
+	}
+	if api, ok := config.chainAPI(blockchain); ok {
+		return newEtherscanAPI(api, f)
+	}
+	return nil
+}
+
+func readDecimals(ctx context.Context, c contractCaller, token TokenContract) (int, error) {
+	result, err := c.ethCall(ctx, token.Address, decimalsSelector)
+	var decimals *big.Int
+	if err == nil {
+		decimals, err = parseQuantity(result)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s decimals error: %w", token.Symbol, err)
+	}
+	return int(decimals.Int64()), nil
+}
+
+func readToken(ctx context.Context, c contractCaller, token TokenContract) (TokenInfo, error) {
+	var info TokenInfo
+	var err error
+	info.Decimals, err = readDecimals(ctx, c, token)
+	if err != nil {
+		return info, err
+	}
+	result, err := c.ethCall(ctx, token.Address, nameSelector)
+	if err == nil {
+		info.Name, err = decodeString(result)
+	}
+	if err != nil {
+		return info, fmt.Errorf("%s name error: %w", token.Symbol, err)
+	}
+	info.TotalSupply, err = readSupply(ctx, c, token, info.Decimals)
+	return info, err
+}
+
+func readSupply(ctx context.Context, c contractCaller, token TokenContract, decimals int) (float64, error) {
+	result, err := c.ethCall(ctx, token.Address, totalSupplySelector)
+	var supply *big.Int
+	if err == nil {
+		supply, err = parseQuantity(result)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s total supply error: %w", token.Symbol, err)
+	}
+	return scale(supply, decimals), nil
+}
+
+// decodeString reads an abi encoded string.
+// Older tokens like MKR return a bytes32 instead
+func decodeString(result string) (string, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(result, "0x"))
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 32 {
+		return strings.TrimRight(string(b), "\x00"), nil
+	}
+	if len(b) < 64 {
+		return "", fmt.Errorf("invalid string %q", result)
+	}
+	offset := new(big.Int).SetBytes(b[:32])
+	if !offset.IsInt64() || offset.Int64()+32 > int64(len(b)) {
+		return "", fmt.Errorf("invalid string offset %s", offset)
+	}
+	start := offset.Int64() + 32
+	length := new(big.Int).SetBytes(b[offset.Int64():start])
+	if !length.IsInt64() || start+length.Int64() > int64(len(b)) {
+		return "", fmt.Errorf("invalid string length %s", length)
+	}
+	return string(b[start : start+length.Int64()]), nil
+}
+
+// trackToken saves the token on first sight and a snapshot of its supply every batch.
+// Returns the total supply or 0 if it can't be read
+func (u updater) trackToken(ctx context.Context, c contractCaller, token TokenContract) (float64, error) {
+	address := strings.ToLower(token.Address)
+	var name *string
+	var decimals *int
+	err := u.pool.QueryRow(ctx, `
+		SELECT name, decimals FROM token WHERE blockchain = $1 AND address = $2;
+	`, token.Blockchain, address).Scan(&name, &decimals)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("token query error: %w", err)
+	}
+	if c == nil {
+		// remember it until it can be read
+		_, err = u.pool.Exec(ctx, `
+			INSERT INTO token (blockchain, address, symbol) VALUES ($1, $2, $3)
+			ON CONFLICT ON CONSTRAINT ux_token_blockchain_address DO NOTHING;
+		`, token.Blockchain, address, token.Symbol)
+		return 0, err
+	}
+	var supply float64
+	if name == nil || decimals == nil {
+		info, err := readToken(ctx, c, token)
+		if err != nil {
+			return 0, err
+		}
+		_, err = u.pool.Exec(ctx, `
+			INSERT INTO token (blockchain, address, symbol, name, decimals) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ON CONSTRAINT ux_token_blockchain_address DO UPDATE
+			SET symbol = $3, name = $4, decimals = $5;
+		`, token.Blockchain, address, token.Symbol, info.Name, info.Decimals)
+		if err != nil {
+			return 0, fmt.Errorf("token insert error: %w", err)
+		}
+		supply = info.TotalSupply
+	} else {
+		supply, err = readSupply(ctx, c, token, *decimals)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = u.pool.Exec(ctx, `
+		INSERT INTO token_supply (token_id, total_supply, batch_at)
+		SELECT token_id, $3, $4 FROM token WHERE blockchain = $1 AND address = $2
+		ON CONFLICT ON CONSTRAINT ux_token_supply_batch DO UPDATE
+		SET total_supply = $3;
+	`, token.Blockchain, address, supply, u.batchAt)
+	if err != nil {
+		return 0, fmt.Errorf("token supply insert error: %w", err)
+	}
+	return supply, nil
+}
+
+// trackTokens tracks the tokens of a blockchain and returns their supplies keyed by symbol.
+// Failures are only logged since balances are still useful without them
+func (u updater) trackTokens(ctx context.Context, c contractCaller, blockchain string, tokens []TokenContract) map[string]float64 {
+	supplies := map[string]float64{}
+	warned := false
+	for _, token := range tokens {
+		if token.Blockchain != blockchain {
+			continue
+		}
+		if c == nil && !warned {
+			fmt.Printf("%s: no node or api to read token contracts. decimals, supplies and percentages are not tracked\n", blockchain)
+			warned = true
+		}
+		supply, err := u.trackToken(ctx, c, token)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		supplies[token.Symbol] = supply
+	}
+	return supplies
+}
+
+// withPercentages sets the share of the supply each wallet holds
+func withPercentages(wallets []Wallet, supply float64) []Wallet {
+	if supply <= 0 {
+		return wallets
+	}
+	for i := range wallets {
+		wallets[i].Percentage = wallets[i].Balance * 100 / supply
+	}
+	return wallets
+}
+
+// runSupply is the total supply of the token of a run saved with its batch. 0 if it wasn't read
+func runSupply(ctx context.Context, conn *pgxpool.Pool, runID int) (float64, error) {
+	var supply float64
+	err := conn.QueryRow(ctx, `
+		SELECT s.total_supply
+		FROM token_supply s
+		JOIN token t USING (token_id)
+		JOIN scrape_run r ON r.batch_at = s.batch_at AND r.blockchain = t.blockchain AND r.symbol = t.symbol
+		WHERE r.run_id = $1;
+	`, runID).Scan(&supply)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("run supply error: %w", err)
+	}
+	return supply, nil
+}
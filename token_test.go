@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReadToken(t *testing.T) {
+	server := fakeEthNode(t, nil, nil)
+	node := newEthNode(RPCConfig{URL: server.URL}, testFetcher(server))
+	info, err := readToken(context.Background(), node, TokenContract{Blockchain: "ethereum", Address: "0xdac17f958d2ee523a2206206994597c13d831ec7", Symbol: "USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TokenInfo{Name: "Tether USD", Decimals: 6, TotalSupply: 39823315849.9}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "string",
+			result: "0x0000000000000000000000000000000000000000000000000000000000000020" +
+				"0000000000000000000000000000000000000000000000000000000000000004" +
+				"4c494e4b00000000000000000000000000000000000000000000000000000000",
+			want: "LINK",
+		},
+		{
+			name:   "bytes32",
+			result: "0x4d616b6572000000000000000000000000000000000000000000000000000000",
+			want:   "Maker",
+		},
+		{
+			name: "length past the end",
+			result: "0x0000000000000000000000000000000000000000000000000000000000000020" +
+				"0000000000000000000000000000000000000000000000000000000000000040" +
+				"4c494e4b00000000000000000000000000000000000000000000000000000000",
+			wantErr: true,
+		},
+		{name: "empty", result: "0x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeString(tt.result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithPercentages(t *testing.T) {
+	wallets := withPercentages([]Wallet{{Balance: 2500}, {Balance: 10}}, 10000)
+	if wallets[0].Percentage != 25 || wallets[1].Percentage != 0.1 {
+		t.Errorf("got %v and %v, want 25 and 0.1", wallets[0].Percentage, wallets[1].Percentage)
+	}
+	wallets = withPercentages([]Wallet{{Balance: 2500}}, 0)
+	if wallets[0].Percentage != 0 {
+		t.Errorf("got %v without a supply, want 0", wallets[0].Percentage)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// transferTolerance is how far apart relative to the amount sent a pair of deltas can be. Covers fees and rounding
+	transferTolerance = 0.001
+	// minTransferConfidence is the least confidence a transfer needs to be netted out of the summary
+	minTransferConfidence = 0.5
+)
+
+// balanceDelta is how much the balance of a whale changed since the previous run
+type balanceDelta struct {
+	WhaleID int
+	Delta   float64
+}
+
+// Transfer is a move between tracked whales inferred from their balances
+type Transfer struct {
+	From   int
+	To     int
+	Amount float64
+	// Confidence from 0 to 1. Lower when the amounts differ or other whales received the same amount
+	Confidence float64
+}
+
+// inferTransfers pairs each decrease with the closest equal increase, largest first.
+// Each whale is paired at most once
+func inferTransfers(deltas []balanceDelta) []Transfer {
+	var outs, ins []balanceDelta
+	for _, d := range deltas {
+		if d.Delta < 0 {
+			outs = append(outs, d)
+		} else if d.Delta > 0 {
+			ins = append(ins, d)
+		}
+	}
+	sort.Slice(outs, func(i, j int) bool { return outs[i].Delta < outs[j].Delta })
+	sort.Slice(ins, func(i, j int) bool { return ins[i].Delta > ins[j].Delta })
+	used := make([]bool, len(ins))
+	var transfers []Transfer
+	for _, out := range outs {
+		sent := -out.Delta
+		best := -1
+		candidates := 0
+		for i, in := range ins {
+			if used[i] || in.WhaleID == out.WhaleID {
+				continue
+			}
+			if math.Abs(in.Delta-sent) > sent*transferTolerance {
+				continue
+			}
+			candidates++
+			if best < 0 || math.Abs(in.Delta-sent) < math.Abs(ins[best].Delta-sent) {
+				best = i
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		used[best] = true
+		closeness := 1 - math.Abs(ins[best].Delta-sent)/(sent*transferTolerance)/2
+		transfers = append(transfers, Transfer{
+			From:       out.WhaleID,
+			To:         ins[best].WhaleID,
+			Amount:     math.Min(sent, ins[best].Delta),
+			Confidence: closeness / float64(candidates),
+		})
+	}
+	return transfers
+}
+
+// inferBatchTransfers records the transfers between whales of every successful run of a batch
+func inferBatchTransfers(ctx context.Context, pool *pgxpool.Pool, batchAt time.Time) error {
+	rows, err := pool.Query(ctx, `
+		SELECT run_id, blockchain, symbol FROM scrape_run
+		WHERE batch_at = $1 AND status = $2;
+	`, batchAt, runSuccess)
+	if err != nil {
+		return fmt.Errorf("transfer runs error: %w", err)
+	}
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		err := rows.Scan(&run.ID, &run.Chain, &run.Symbol)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("transfer runs error: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	for _, run := range runs {
+		count, err := inferRunTransfers(ctx, pool, run, batchAt)
+		if err != nil {
+			return fmt.Errorf("%s %s transfer error: %w", run.Chain, run.Symbol, err)
+		}
+		if count > 0 {
+			fmt.Printf("%s %s: %d transfers between whales\n", run.Chain, run.Symbol, count)
+		}
+	}
+	return nil
+}
+
+// inferRunTransfers compares a run to the previous successful run of the same asset
+func inferRunTransfers(ctx context.Context, pool *pgxpool.Pool, run Run, batchAt time.Time) (int, error) {
+	var previous int
+	err := pool.QueryRow(ctx, `
+		SELECT run_id FROM scrape_run
+		WHERE blockchain = $1 AND symbol = $2 AND status = $3 AND batch_at < $4
+		ORDER BY batch_at DESC
+		LIMIT 1;
+	`, run.Chain, run.Symbol, runSuccess, batchAt).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// only whales in both runs. others may have just entered or left the rich list
+	rows, err := pool.Query(ctx, `
+		SELECT b.whale_id, b.value - p.value
+		FROM balance b
+		JOIN balance p ON p.whale_id = b.whale_id AND p.run_id = $2
+		WHERE b.run_id = $1
+		AND b.value <> p.value;
+	`, run.ID, previous)
+	if err != nil {
+		return 0, err
+	}
+	var deltas []balanceDelta
+	for rows.Next() {
+		var d balanceDelta
+		err := rows.Scan(&d.WhaleID, &d.Delta)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deltas = append(deltas, d)
+	}
+	rows.Close()
+	transfers := inferTransfers(deltas)
+	if len(transfers) == 0 {
+		return 0, nil
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for _, t := range transfers {
+		batch.Queue(`
+			INSERT INTO transfer (run_id, from_whale_id, to_whale_id, amount, confidence)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ON CONSTRAINT ux_transfer_run_whales DO UPDATE
+			SET amount = $4, confidence = $5;
+		`, run.ID, t.From, t.To, t.Amount, t.Confidence)
+	}
+	return len(transfers), commit(ctx, tx, batch)
+}
+
+// generate_internal_flows reads what each tracked whale moved into exchanges in every batch
+// of the window and the lookback before it, less what exchanges moved out to it
+func generate_internal_flows(ctx context.Context, conn *pgx.Conn, blockchain, symbol string, config HolderConfig) ([]internalMove, error) {
+	query := `
+	select
+		extract(epoch from r.batch_at)::bigint as epoch,
+		case when tw.owner_type = 'exchange' then t.from_whale_id else t.to_whale_id end,
+		sum(case when tw.owner_type = 'exchange' then t.amount else -t.amount end)
+	from transfer t
+	join scrape_run r using(run_id)
+	join whale fw on fw.whale_id = t.from_whale_id
+	join whale tw on tw.whale_id = t.to_whale_id
+	where r.batch_at > now() - make_interval(days => $4::int + $5::int)
+	and r.symbol = $1
+	and r.blockchain = $2
+	and t.confidence >= $3
+	-- only moves between an exchange and a whale that is not one
+	and (fw.owner_type = 'exchange') <> (tw.owner_type = 'exchange')
+	group by 1, 2
+	;
+	`
+	rows, err := conn.Query(ctx, query, symbol, blockchain, minTransferConfidence, config.windowDays(), config.lookbackDays())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+	var moves []internalMove
+	for rows.Next() {
+		var m internalMove
+		err := rows.Scan(&m.Date, &m.WhaleID, &m.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		moves = append(moves, m)
+	}
+	return moves, rows.Err()
+}
+
+// internalFlow sums what tracked whales moved into exchanges over the last hours by their bucket
+func internalFlow(points []Point, key string, hours int) map[string]float64 {
+	flow := map[string]float64{}
+	for i := len(points) - hours; i < len(points); i++ {
+		if i < 1 {
+			// moves into the oldest point happened before the window
+			continue
+		}
+		for bucket, amount := range points[i].Assets[key].Internal {
+			flow[bucket] += amount
+		}
+	}
+	return flow
+}
+
+// netted takes moves between tracked whales out of a series and puts them back in the bucket
+// of the whale on the other side so they don't count as coins entering or leaving exchanges.
+// Only stablecoins moving in and out of exchanges suggest buying so the rest of a stablecoin is left out
+func netted(s Series, internal map[string]float64, stablecoin bool) Series {
+	for bucket, amount := range internal {
+		s.Exchange -= amount
+		switch bucket {
+		case bucketWrap:
+			s.Wrap += amount
+		case bucketStake:
+			s.Stake += amount
+		case bucketDiamondHands:
+			s.DiamondHands += amount
+		case bucketPaperHands:
+			s.PaperHands += amount
+		}
+	}
+	if stablecoin {
+		return Series{Exchange: s.Exchange}
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferTransfers(t *testing.T) {
+	got := inferTransfers([]balanceDelta{
+		// cold wallet to exchange with a fee
+		{WhaleID: 1, Delta: -1000},
+		{WhaleID: 2, Delta: 999.5},
+		// two exchanges received the same amount
+		{WhaleID: 3, Delta: -50},
+		{WhaleID: 4, Delta: 50},
+		{WhaleID: 5, Delta: 50},
+		// no match
+		{WhaleID: 6, Delta: -10},
+		{WhaleID: 7, Delta: 20},
+	})
+	want := []Transfer{
+		{From: 1, To: 2, Amount: 999.5, Confidence: 0.75},
+		{From: 3, To: 4, Amount: 50, Confidence: 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSummarizeNetsInternalFlows(t *testing.T) {
+	assets := []Asset{{Key: "BTC", Symbol: "BTC", Price: 40000}}
+	builder := newSeriesBuilder(HolderConfig{}, hour)
+	for _, m := range []internalMove{
+		// a cold wallet moved more than the tolerance to an exchange
+		{Date: 2 * hour, WhaleID: 1, Amount: 500},
+		// a contract is not a holder
+		{Date: 2 * hour, WhaleID: 3, Amount: 10},
+		// a paper hand moved to an exchange
+		{Date: 2 * hour, WhaleID: 4, Amount: 30},
+	} {
+		builder.move(m)
+	}
+	for _, h := range [][]balanceRow{
+		history(1, "unknown", false, 0, 1000, 1000, 500),
+		history(2, "exchange", false, 0, 1000, 1000, 1540),
+		history(3, "unknown", true, 0, 30, 30, 20),
+		history(4, "unknown", false, 0, 100, 60, 30),
+	} {
+		for _, row := range h {
+			builder.add(row)
+		}
+	}
+	var points []Point
+	for _, s := range builder.series() {
+		points = append(points, Point{Date: s.Date, Assets: map[string]Series{"BTC": s}})
+	}
+	if len(points) != 2 || points[1].Assets["BTC"].DiamondHandsCount != 1 {
+		t.Fatalf("got %+v, want the cold wallet to stay a diamond hand", points)
+	}
+	got := summarize(points, assets)
+	if strings.Contains(got, "$") || strings.Contains(got, "%") {
+		t.Errorf("%q counts an internal move", got)
+	}
+	if usd := usdChange(points, assets[0], 1); usd != 0 {
+		t.Errorf("got %f usd, want 0", usd)
+	}
+	want := map[string]float64{"diamond_hands": 500, "paper_hands": 30, "other": 10}
+	if flow := internalFlow(points, "BTC", 1); !reflect.DeepEqual(flow, want) {
+		t.Errorf("got internal flow %v, want %v", flow, want)
+	}
+}